@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SentAlert records the day an understaffed alert was sent, so that organizers get at most one alert per day
+type SentAlert struct {
+	gorm.Model
+	Date string `gorm:"uniqueIndex"`
+}
+
+// understaffedRole describes a role below its minimum on an upcoming race
+type understaffedRole struct {
+	Race      *Race
+	Role      RoleConfig
+	Minimum   int
+	Names     []string
+	DaysUntil int
+}
+
+func (config ProgramConfig) alertDays() int {
+	if config.AlertDays > 0 {
+		return config.AlertDays
+	}
+	return 7
+}
+
+// Returns the roles below their minimum on the races within the alert window, in date order
+func findUnderstaffedRoles(progConfig ProgramConfig, db *gorm.DB, currentTime time.Time) []understaffedRole {
+	loc := progConfig.timezone()
+	today := currentTime.In(loc).Format(time.DateOnly)
+	lastDay := currentTime.In(loc).AddDate(0, 0, progConfig.alertDays()).Format(time.DateOnly)
+	todayStart, _ := time.ParseInLocation(time.DateOnly, today, loc)
+
+	races := []*Race{}
+	result := preloadSignups(db.Preload("Minimums")).Where("date >= ? AND date <= ?", today, lastDay).Where("cancelled = ?", false).Order("date").Order("name").Find(&races)
+	if result.Error != nil {
+		log.Fatalf("Error getting database races: %v", result.Error)
+	}
+
+	understaffed := []understaffedRole{}
+	for _, race := range races {
+		if !race.startTime(progConfig).After(currentTime) {
+			continue
+		}
+
+		for _, role := range progConfig.roles() {
+			minimum := race.minimumFor(role.Name, role.Minimum)
+			users := race.usersFor(role.Name)
+			if len(users) >= minimum {
+				continue
+			}
+
+			names := []string{}
+			for _, u := range users {
+				names = append(names, u.Name)
+			}
+
+			understaffed = append(understaffed, understaffedRole{
+				Race:      race,
+				Role:      role,
+				Minimum:   minimum,
+				Names:     names,
+				DaysUntil: int(math.Round(race.Time(loc).Sub(todayStart).Hours() / 24)),
+			})
+		}
+	}
+	return understaffed
+}
+
+// Alerts the organizers by email and webhook of every upcoming race with a role below its
+// minimum, at most once per day
+func sendUnderstaffedAlerts(progConfig ProgramConfig, db *gorm.DB, services SyncServices, currentTime time.Time) {
+	if len(progConfig.Organizers) == 0 && services.Webhook == nil {
+		return
+	}
+
+	today := currentTime.In(progConfig.timezone()).Format(time.DateOnly)
+	err := db.Where(&SentAlert{Date: today}).First(&SentAlert{}).Error
+	if err == nil {
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Fatalf("Database error: %v", err)
+	}
+
+	understaffed := findUnderstaffedRoles(progConfig, db, currentTime)
+	if len(understaffed) == 0 {
+		return
+	}
+
+	races := map[uint]bool{}
+	lines := []string{}
+	for _, u := range understaffed {
+		races[u.Race.ID] = true
+
+		signedUp := "no one signed up"
+		if len(u.Names) > 0 {
+			signedUp = fmt.Sprintf("signed up: %v", strings.Join(u.Names, ", "))
+		}
+		lines = append(lines, fmt.Sprintf("%v on %v (%v days): %v %v of %v, %v", u.Race.Name, u.Race.Date, u.DaysUntil, u.Role.label(), len(u.Names), u.Minimum, signedUp))
+	}
+
+	message := EmailMessage{
+		Subject: fmt.Sprintf("Upcoming races need more volunteers (%v)", len(races)),
+		Body:    fmt.Sprintf("The following races are below their signup minimums:\n\n%v\n", strings.Join(lines, "\n")),
+	}
+
+	sent := false
+	if services.Notifier != nil {
+		for _, organizer := range progConfig.Organizers {
+			message.To = organizer
+			if err := services.Notifier.Send(message); err != nil {
+				log.Printf("Unable to send understaffed alert to %v: %v\n", organizer, err)
+			} else {
+				sent = true
+			}
+		}
+	}
+
+	if services.Webhook != nil {
+		message.To = ""
+		if err := services.Webhook.Send(message); err != nil {
+			log.Printf("Unable to send understaffed alert to the webhook: %v\n", err)
+		} else {
+			sent = true
+		}
+	}
+
+	if sent {
+		db.Create(&SentAlert{Date: today})
+		log.Printf("Sent understaffed alert for %v races\n", len(races))
+	}
+}
+
+// webhookNotifier posts messages as JSON with a "text" field, as accepted by Slack and
+// similar chat webhooks
+type webhookNotifier struct {
+	url string
+}
+
+func (config ProgramConfig) newWebhook() Notifier {
+	if len(config.OrganizerWebhookURL) == 0 {
+		return nil
+	}
+	return &webhookNotifier{url: config.OrganizerWebhookURL}
+}
+
+func (notifier *webhookNotifier) Send(message EmailMessage) error {
+	payload, err := json.Marshal(map[string]string{"text": fmt.Sprintf("%v\n%v", message.Subject, message.Body)})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(notifier.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Creates a race the given number of days after the current time, with the users signed up for RC
+func createAlertRace(t *testing.T, db *gorm.DB, currentTime time.Time, name string, days int, users ...*User) *Race {
+	t.Helper()
+
+	race := &Race{Name: name, Date: currentTime.AddDate(0, 0, days).Format(time.DateOnly), StartTime: "18:00"}
+	if err := db.Create(race).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range users {
+		db.Create(&RaceSignup{RaceID: race.ID, UserID: user.ID, Role: "RC"})
+	}
+	return race
+}
+
+func TestFindUnderstaffedRoles(t *testing.T) {
+	progConfig := ProgramConfig{Roles: []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 4, Minimum: 2}, {Name: "Renters", EntryLimit: -1}}}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	currentTime := time.Date(2030, 6, 1, 9, 0, 0, 0, progConfig.timezone())
+
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+
+	createAlertRace(t, db, currentTime, "Short", 2, user)
+	overridden := createAlertRace(t, db, currentTime, "Overridden", 3)
+	db.Create(&RaceMinimum{RaceID: overridden.ID, Role: "RC", Minimum: 0})
+	cancelled := createAlertRace(t, db, currentTime, "Cancelled", 4)
+	db.Model(cancelled).Update("Cancelled", true)
+	createAlertRace(t, db, currentTime, "Later", 10)
+
+	understaffed := findUnderstaffedRoles(progConfig, db, currentTime)
+	if len(understaffed) != 1 {
+		t.Fatalf("expected only the short race, got %v", understaffed)
+	}
+	u := understaffed[0]
+	if u.Race.Name != "Short" || u.Role.Name != "RC" || u.Minimum != 2 || fmt.Sprint(u.Names) != "[A]" || u.DaysUntil != 2 {
+		t.Errorf("unexpected understaffed role %+v", u)
+	}
+}
+
+func TestFindUnderstaffedRolesUsesLegacyFormMinimum(t *testing.T) {
+	progConfig := ProgramConfig{
+		FormRC:      ProgramConfigForm{TableName: "RC", EntryLimit: 4, Minimum: 2},
+		FormRentals: ProgramConfigForm{TableName: "Renters", EntryLimit: -1},
+	}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	currentTime := time.Date(2030, 6, 1, 9, 0, 0, 0, progConfig.timezone())
+	createAlertRace(t, db, currentTime, "Race 1", 2)
+
+	understaffed := findUnderstaffedRoles(progConfig, db, currentTime)
+	if len(understaffed) != 1 || understaffed[0].Role.Name != "RC" || understaffed[0].Minimum != 2 {
+		t.Errorf("expected the FormRC minimum to apply, got %v", understaffed)
+	}
+}
+
+func TestSendUnderstaffedAlertsOncePerDay(t *testing.T) {
+	progConfig := ProgramConfig{
+		Roles:      []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 4, Minimum: 1}},
+		Organizers: []string{"organizer@example.org"},
+	}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	services := newMemoryServices()
+	notifier := services.Notifier.(*memoryNotifier)
+	currentTime := time.Date(2030, 6, 1, 9, 0, 0, 0, progConfig.timezone())
+	createAlertRace(t, db, currentTime, "Race 1", 3)
+
+	sendUnderstaffedAlerts(progConfig, db, services, currentTime)
+	sendUnderstaffedAlerts(progConfig, db, services, currentTime.Add(6*time.Hour))
+	if len(notifier.Sent) != 1 || notifier.Sent[0].To != "organizer@example.org" {
+		t.Fatalf("expected one alert for the day, got %v", notifier.Sent)
+	}
+
+	sendUnderstaffedAlerts(progConfig, db, services, currentTime.AddDate(0, 0, 1))
+	if len(notifier.Sent) != 2 {
+		t.Errorf("expected another alert the next day, got %v", notifier.Sent)
+	}
+}
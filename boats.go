@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const capacitySourceBoats = "boats"
+
+// Boat is a rental boat assigned to members signed up for a role with Boats set
+type Boat struct {
+	gorm.Model
+	Name       string
+	Class      string
+	SailNumber string
+	Capacity   int
+	Active     bool
+}
+
+// Returns the boat name along with the class and sail number when known
+func (boat Boat) label() string {
+	details := boat.Class
+	if len(boat.SailNumber) > 0 {
+		details = fmt.Sprintf("%v #%v", details, boat.SailNumber)
+	}
+
+	if len(details) == 0 {
+		return boat.Name
+	}
+	return fmt.Sprintf("%v (%v)", boat.Name, details)
+}
+
+// Returns the roles whose signups are assigned boats
+func (config ProgramConfig) boatRoles() []RoleConfig {
+	roles := []RoleConfig{}
+	for _, role := range config.roles() {
+		if role.Boats {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Returns the boats available for rentals in assignment order
+func activeBoats(db *gorm.DB) ([]*Boat, error) {
+	boats := []*Boat{}
+	err := db.Where("active = ?", true).Order("name").Find(&boats).Error
+	return boats, err
+}
+
+// Returns the upcoming races that have not been cancelled, loaded with findRace
+func upcomingBoatRaces(db *gorm.DB, progConfig ProgramConfig, currentTime time.Time) []*Race {
+	races := []*Race{}
+	today := currentTime.In(progConfig.timezone()).Format(time.DateOnly)
+	err := preloadSignups(preloadRaceDetails(db)).Where("date >= ? AND cancelled = ?", today, false).Order("date").Find(&races).Error
+	if err != nil {
+		log.Fatalf("Error getting database races: %v", err)
+	}
+	return races
+}
+
+// Sets the capacity of each boat role on upcoming races to the number of active boats.
+// Capacities from the schedule or the capacity command are left as they are. Returns the
+// emails for the users promoted from the waitlist.
+func updateBoatCapacities(db *gorm.DB, progConfig ProgramConfig, currentTime time.Time) []EmailMessage {
+	messages := []EmailMessage{}
+	roles := progConfig.boatRoles()
+	if len(roles) == 0 {
+		return messages
+	}
+
+	boats, err := activeBoats(db)
+	if err != nil {
+		log.Fatalf("Error getting database boats: %v", err)
+	}
+
+	count := len(boats)
+	for _, race := range upcomingBoatRaces(db, progConfig, currentTime) {
+		for _, role := range roles {
+			existing := race.capacityFor(role.Name)
+			if existing != nil && existing.Source != capacitySourceBoats {
+				continue
+			} else if existing == nil || existing.Limit != count {
+				messages = append(messages, setRaceCapacity(db, progConfig, race, role.Name, count, capacitySourceBoats)...)
+			}
+		}
+	}
+	return messages
+}
+
+// Assigns a free active boat to each signup of the role without one, in signup order.
+// Boats are shared between all boat roles, so a boat is only assigned once per race.
+// Returns true if any boat was assigned.
+func (race *Race) assignBoats(db *gorm.DB, role string, boats []*Boat) (bool, error) {
+	taken := map[uint]bool{}
+	for _, signup := range race.Signups {
+		if signup.BoatID != nil {
+			taken[*signup.BoatID] = true
+		}
+	}
+
+	assigned := false
+	for _, signup := range race.Signups {
+		if signup.Role != role || signup.BoatID != nil {
+			continue
+		}
+
+		for _, boat := range boats {
+			if taken[boat.ID] {
+				continue
+			}
+
+			if err := db.Model(&RaceSignup{}).Where("id = ?", signup.ID).Update("BoatID", boat.ID).Error; err != nil {
+				return assigned, err
+			}
+			signup.BoatID = &boat.ID
+			signup.Boat = boat
+			taken[boat.ID] = true
+			assigned = true
+
+			log.Printf("Assigned %v to %v for %v\n", boat.Name, signup.User.Email, race.Name)
+			break
+		}
+	}
+
+	return assigned, nil
+}
+
+// Returns the boat assigned to the user for the role, or nil if there is none
+func (race Race) boatFor(role string, userID uint) *Boat {
+	for _, signup := range race.Signups {
+		if signup.Role == role && signup.UserID == userID {
+			return signup.Boat
+		}
+	}
+	return nil
+}
+
+// Assigns boats to the signups of each boat role on upcoming races, such as after boats
+// are added or reactivated, marking the calendar events of changed races as outdated
+func assignUpcomingBoats(db *gorm.DB, progConfig ProgramConfig, currentTime time.Time) {
+	roles := progConfig.boatRoles()
+	if len(roles) == 0 {
+		return
+	}
+
+	boats, err := activeBoats(db)
+	if err != nil {
+		log.Fatalf("Error getting database boats: %v", err)
+	}
+
+	for _, race := range upcomingBoatRaces(db, progConfig, currentTime) {
+		changed := false
+		for _, role := range roles {
+			assigned, err := race.assignBoats(db, role.Name, boats)
+			if err != nil {
+				log.Fatalf("Database error: %v", err)
+			}
+			changed = assigned || changed
+		}
+		if changed {
+			db.Model(&Race{}).Where("id = ?", race.ID).Update("CalendarOutdated", true)
+		}
+	}
+}
+
+// Removes the boat from the signups of upcoming races so that it can be replaced
+func releaseBoat(db *gorm.DB, progConfig ProgramConfig, boat *Boat, currentTime time.Time) {
+	for _, race := range upcomingBoatRaces(db, progConfig, currentTime) {
+		for _, signup := range race.Signups {
+			if signup.BoatID == nil || *signup.BoatID != boat.ID {
+				continue
+			}
+
+			if err := db.Model(&RaceSignup{}).Where("id = ?", signup.ID).Update("BoatID", nil).Error; err != nil {
+				log.Fatalf("Database error: %v", err)
+			}
+			signup.BoatID = nil
+			signup.Boat = nil
+			db.Model(&Race{}).Where("id = ?", race.ID).Update("CalendarOutdated", true)
+			log.Printf("Released %v from %v for %v\n", boat.Name, signup.User.Email, race.Name)
+		}
+	}
+}
+
+// Adds, changes, or lists the rental boats. Changes update the capacity of the boat
+// roles and the boat assignments of upcoming races.
+func runBoatsCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("boats", flag.ExitOnError)
+	name := flags.String("name", "", "name of the boat to add or change - lists all boats if not provided")
+	class := flags.String("class", "", "class of the boat, such as J/22")
+	sailNumber := flags.String("sail", "", "sail number of the boat")
+	capacity := flags.Int("capacity", 0, "number of people the boat holds, shown in the boat list")
+	active := flags.Bool("active", true, "whether the boat is available for rentals")
+	flags.Parse(args)
+
+	db := progConfig.openDatabase()
+
+	if len(*name) == 0 {
+		listBoats(db)
+		return
+	}
+
+	changed := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		changed[f.Name] = true
+	})
+
+	messages := []EmailMessage{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		boat := &Boat{}
+		err := tx.Where(&Boat{Name: *name}).First(boat).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			boat = &Boat{Name: *name, Active: true}
+			log.Printf("Adding boat %v\n", *name)
+		} else if err != nil {
+			return err
+		}
+
+		if changed["class"] {
+			boat.Class = *class
+		}
+		if changed["sail"] {
+			boat.SailNumber = *sailNumber
+		}
+		if changed["capacity"] {
+			boat.Capacity = *capacity
+		}
+		if changed["active"] {
+			boat.Active = *active
+		}
+		if err := tx.Save(boat).Error; err != nil {
+			return err
+		}
+
+		currentTime := time.Now()
+		if !boat.Active {
+			releaseBoat(tx, progConfig, boat, currentTime)
+		}
+		messages = updateBoatCapacities(tx, progConfig, currentTime)
+		assignUpcomingBoats(tx, progConfig, currentTime)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Unable to update boat: %v", err)
+	}
+
+	sendMessages(progConfig.newNotifier(), messages)
+}
+
+func listBoats(db *gorm.DB) {
+	boats := []*Boat{}
+	if err := db.Order("name").Find(&boats).Error; err != nil {
+		log.Fatalf("Error getting database boats: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCLASS\tSAIL\tCAPACITY\tACTIVE")
+	for _, boat := range boats {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", boat.Name, boat.Class, boat.SailNumber, boat.Capacity, boat.Active)
+	}
+	w.Flush()
+}
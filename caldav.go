@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// CalDAVConfig defines the calendar collection used when CalendarBackend is "caldav"
+type CalDAVConfig struct {
+	URL      string
+	Username string
+	Password string
+}
+
+// caldavCalendarStore keeps each race event as an iCalendar resource in a CalDAV
+// collection. The event ID is the href of the resource, and the event ETag is the
+// ETag of the resource when it was read, so that an update does not overwrite a change
+// made on the server between reading and writing the event.
+type caldavCalendarStore struct {
+	client     *http.Client
+	collection *url.URL
+	config     CalDAVConfig
+}
+
+func newCalDAVCalendarStore(config CalDAVConfig) *caldavCalendarStore {
+	collection, err := url.Parse(config.URL)
+	if err != nil {
+		log.Fatalf("Invalid CalDAV URL '%v': %v", config.URL, err)
+	}
+	if !strings.HasSuffix(collection.Path, "/") {
+		collection.Path += "/"
+	}
+
+	return &caldavCalendarStore{
+		client:     &http.Client{Timeout: 30 * time.Second},
+		collection: collection,
+		config:     config,
+	}
+}
+
+// Sends a request for the resource at the href, returning an error for any non-2xx status
+func (store *caldavCalendarStore) do(method string, href string, body string, headers map[string]string) (*http.Response, error) {
+	target, err := store.collection.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = nil
+	if len(body) > 0 {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(store.config.Username) > 0 {
+		req.SetBasicAuth(store.config.Username, store.config.Password)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := store.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%v %v returned %v", method, target, resp.Status)
+	}
+	return resp, nil
+}
+
+func (store *caldavCalendarStore) GetEvent(eventID string) (*calendar.Event, error) {
+	resp, err := store.do(http.MethodGet, eventID, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := parseICSEvent(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %v: %v", eventID, err)
+	}
+
+	event.Id = eventID
+	event.Etag = resp.Header.Get("ETag")
+	return event, nil
+}
+
+func (store *caldavCalendarStore) InsertEvent(event *calendar.Event) (*calendar.Event, error) {
+	uid := make([]byte, 16)
+	if _, err := rand.Read(uid); err != nil {
+		return nil, err
+	}
+
+	result := *event
+	result.ICalUID = fmt.Sprintf("%v@sailingsignup", hex.EncodeToString(uid))
+	href := store.collection.JoinPath(hex.EncodeToString(uid) + ".ics").Path
+
+	etag, err := store.put(href, &result, map[string]string{"If-None-Match": "*"})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Id = href
+	result.Etag = etag
+	return &result, nil
+}
+
+// Attendees are listed on the event, but CalDAV has no request to notify them, so notify
+// is ignored and the sync emails them instead
+func (store *caldavCalendarStore) UpdateEvent(eventID string, event *calendar.Event, notify bool) (*calendar.Event, error) {
+	headers := map[string]string{}
+	if len(event.Etag) > 0 {
+		headers["If-Match"] = event.Etag
+	}
+
+	etag, err := store.put(eventID, event, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *event
+	result.Id = eventID
+	result.Etag = etag
+	return &result, nil
+}
+
+func (store *caldavCalendarStore) DeleteEvent(eventID string) error {
+	resp, err := store.do(http.MethodDelete, eventID, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (store *caldavCalendarStore) NotifiesAttendees() bool {
+	return false
+}
+
+// Writes the event as a calendar resource, returning the new ETag if the server provides one
+func (store *caldavCalendarStore) put(href string, event *calendar.Event, headers map[string]string) (string, error) {
+	var body strings.Builder
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//SailingSignup//Race Schedule//EN",
+	}
+	lines = append(lines, eventLines(event, time.Now().UTC().Format(icsTimeFormat))...)
+	lines = append(lines, "END:VCALENDAR")
+	for _, line := range lines {
+		body.WriteString(icsFold(line))
+	}
+
+	headers["Content-Type"] = "text/calendar; charset=utf-8"
+	resp, err := store.do(http.MethodPut, href, body.String(), headers)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	return resp.Header.Get("ETag"), nil
+}
+
+// Reads the first VEVENT of an iCalendar resource
+func parseICSEvent(data string) (*calendar.Event, error) {
+	// Unfold continuation lines before splitting the content lines
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+
+	var event *calendar.Event = nil
+	for _, line := range strings.Split(data, "\n") {
+		nameAndParams, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		params := strings.Split(nameAndParams, ";")
+		name := strings.ToUpper(params[0])
+
+		if name == "BEGIN" && value == "VEVENT" {
+			event = &calendar.Event{Attendees: []*calendar.EventAttendee{}}
+			continue
+		} else if event == nil {
+			continue
+		} else if name == "END" && value == "VEVENT" {
+			return event, nil
+		}
+
+		switch name {
+		case "UID":
+			event.ICalUID = value
+		case "SUMMARY":
+			event.Summary = icsUnescape(value)
+		case "DESCRIPTION":
+			event.Description = icsUnescape(value)
+		case "LOCATION":
+			event.Location = icsUnescape(value)
+		case "STATUS":
+			event.Status = strings.ToLower(value)
+		case "DTSTART", "DTEND":
+			t, err := parseICSTime(value, params[1:])
+			if err != nil {
+				return nil, err
+			}
+			dateTime := &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}
+			if name == "DTSTART" {
+				event.Start = dateTime
+			} else {
+				event.End = dateTime
+			}
+		case "ATTENDEE":
+			attendee := &calendar.EventAttendee{Email: strings.TrimPrefix(strings.TrimPrefix(value, "mailto:"), "MAILTO:")}
+			for _, param := range params[1:] {
+				if cn, found := strings.CutPrefix(param, "CN="); found {
+					attendee.DisplayName = strings.Trim(cn, "\"")
+				}
+			}
+			event.Attendees = append(event.Attendees, attendee)
+		}
+	}
+
+	return nil, fmt.Errorf("no complete VEVENT found")
+}
+
+// Parses a UTC, local with TZID, or all day DTSTART or DTEND value
+func parseICSTime(value string, params []string) (time.Time, error) {
+	loc := time.UTC
+	for _, param := range params {
+		if tzid, found := strings.CutPrefix(param, "TZID="); found {
+			tz, err := time.LoadLocation(strings.Trim(tzid, "\""))
+			if err != nil {
+				return time.Time{}, err
+			}
+			loc = tz
+		}
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icsTimeFormat, value)
+	} else if len(value) == len("20060102") {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+func icsUnescape(text string) string {
+	return strings.NewReplacer(
+		"\\\\", "\\",
+		"\\;", ";",
+		"\\,", ",",
+		"\\n", "\n",
+		"\\N", "\n",
+	).Replace(text)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// fakeCalDAVServer keeps calendar resources in memory by path, checking the preconditions
+// of each PUT against the current ETag of the resource
+type fakeCalDAVServer struct {
+	lock      sync.Mutex
+	resources map[string]string
+	etags     map[string]string
+	requests  []*http.Request
+	nextETag  int
+}
+
+func newFakeCalDAVServer(t *testing.T) (*fakeCalDAVServer, *httptest.Server) {
+	fake := &fakeCalDAVServer{resources: map[string]string{}, etags: map[string]string{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (fake *fakeCalDAVServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.requests = append(fake.requests, r)
+
+	if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	etag, exists := fake.etags[r.URL.Path]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, fake.resources[r.URL.Path])
+	case http.MethodPut:
+		if match := r.Header.Get("If-Match"); len(match) > 0 && match != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		} else if r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		fake.nextETag += 1
+		fake.resources[r.URL.Path] = string(data)
+		fake.etags[r.URL.Path] = fmt.Sprintf("\"%d\"", fake.nextETag)
+		w.Header().Set("ETag", fake.etags[r.URL.Path])
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(fake.resources, r.URL.Path)
+		delete(fake.etags, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Returns the last request made to the server
+func (fake *fakeCalDAVServer) lastRequest() *http.Request {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.requests[len(fake.requests)-1]
+}
+
+func TestCalDAVEventRoundTrip(t *testing.T) {
+	fake, server := newFakeCalDAVServer(t)
+	store := newCalDAVCalendarStore(CalDAVConfig{URL: server.URL + "/calendars/races", Username: "user", Password: "secret"})
+
+	event := &calendar.Event{
+		Summary:     "Race 1, Spring; Series",
+		Description: "RC: A, B\n" + strings.Repeat("A long description that needs folding. ", 4),
+		Location:    "Main Dock",
+		Start:       &calendar.EventDateTime{DateTime: "2030-06-01T18:00:00-04:00"},
+		End:         &calendar.EventDateTime{DateTime: "2030-06-01T21:00:00-04:00"},
+		Attendees:   []*calendar.EventAttendee{{Email: "a@example.org", DisplayName: "A Sailor"}},
+	}
+
+	inserted, err := store.InsertEvent(event)
+	if err != nil {
+		t.Fatalf("inserting: %v", err)
+	}
+	if !strings.HasPrefix(inserted.Id, "/calendars/races/") || !strings.HasSuffix(inserted.Id, ".ics") {
+		t.Errorf("expected the event ID to be the href in the collection, got %v", inserted.Id)
+	}
+	if header := fake.lastRequest().Header.Get("If-None-Match"); header != "*" {
+		t.Errorf("expected the insert to require a new resource, got If-None-Match %q", header)
+	}
+
+	stored, err := store.GetEvent(inserted.Id)
+	if err != nil {
+		t.Fatalf("getting: %v", err)
+	}
+	if stored.Id != inserted.Id || stored.Etag != inserted.Etag || len(stored.Etag) == 0 {
+		t.Errorf("expected the href and ETag %v %v to round trip, got %v %v", inserted.Id, inserted.Etag, stored.Id, stored.Etag)
+	}
+	if stored.Summary != event.Summary || stored.Description != event.Description || stored.Location != event.Location {
+		t.Errorf("expected the event text to round trip, got %q, %q, %q", stored.Summary, stored.Description, stored.Location)
+	}
+	if start, _ := time.Parse(time.RFC3339, stored.Start.DateTime); !start.Equal(time.Date(2030, 6, 1, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected start %v", stored.Start.DateTime)
+	}
+	if len(stored.Attendees) != 1 || stored.Attendees[0].Email != "a@example.org" || stored.Attendees[0].DisplayName != "A Sailor" {
+		t.Errorf("unexpected attendees %v", stored.Attendees)
+	}
+
+	stored.Summary = "CANCELLED: Race 1"
+	updated, err := store.UpdateEvent(stored.Id, stored, true)
+	if err != nil {
+		t.Fatalf("updating: %v", err)
+	}
+	if header := fake.lastRequest().Header.Get("If-Match"); header != inserted.Etag {
+		t.Errorf("expected the update to match the read ETag %v, got %q", inserted.Etag, header)
+	}
+	if updated.Etag == inserted.Etag || len(updated.Etag) == 0 {
+		t.Errorf("expected a new ETag after the update, got %v", updated.Etag)
+	}
+
+	// An update from a stale read fails instead of overwriting the newer event
+	if _, err := store.UpdateEvent(stored.Id, stored, false); err == nil || !strings.Contains(err.Error(), "412") {
+		t.Errorf("expected a stale update to fail with 412, got %v", err)
+	}
+
+	if err := store.DeleteEvent(updated.Id); err != nil {
+		t.Fatalf("deleting: %v", err)
+	}
+	if _, err := store.GetEvent(updated.Id); err == nil {
+		t.Errorf("expected the deleted event to be gone")
+	}
+}
+
+func TestParseICSEvent(t *testing.T) {
+	data := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VTIMEZONE",
+		"TZID:America/New_York",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:abc@example.org",
+		"DTSTART;TZID=America/New_York:20300601T180000",
+		"DTEND:20300602T010000Z",
+		"SUMMARY:Race 1\\, Spring\\; Series",
+		"DESCRIPTION:First line\\nSecond ",
+		" line",
+		"LOCATION:Main Dock",
+		"STATUS:CANCELLED",
+		"ATTENDEE;CN=\"A Sailor\";ROLE=REQ-PARTICIPANT:MAILTO:a@example.org",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	event, err := parseICSEvent(data)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	if event.ICalUID != "abc@example.org" || event.Summary != "Race 1, Spring; Series" || event.Location != "Main Dock" || event.Status != "cancelled" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Description != "First line\nSecond line" {
+		t.Errorf("expected the folded description to be unfolded, got %q", event.Description)
+	}
+	if start, _ := time.Parse(time.RFC3339, event.Start.DateTime); !start.Equal(time.Date(2030, 6, 1, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the TZID start to be read in its zone, got %v", event.Start.DateTime)
+	}
+	if end, _ := time.Parse(time.RFC3339, event.End.DateTime); !end.Equal(time.Date(2030, 6, 2, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected end %v", event.End.DateTime)
+	}
+	if len(event.Attendees) != 1 || event.Attendees[0].Email != "a@example.org" || event.Attendees[0].DisplayName != "A Sailor" {
+		t.Errorf("unexpected attendees %v", event.Attendees)
+	}
+
+	if _, err := parseICSEvent("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:abc\r\n"); err == nil {
+		t.Errorf("expected an error for an incomplete event")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+const emailRaceCancelled = "race-cancelled"
+
+// Marks a race as cancelled, or reinstates a cancelled race. The race is removed from the
+// form options and its calendar event is marked as cancelled on the next sync, which also
+// notifies everyone signed up through the calendar, or by email for calendars that cannot
+// notify attendees. The signups are kept as they were for the history.
+func runCancelRaceCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("cancel-race", flag.ExitOnError)
+	raceName := flags.String("race", "", "name of the race to cancel")
+	date := flags.String("date", "", "date of the race to cancel, as YYYY-MM-DD - required when more than one race has the name")
+	reason := flags.String("reason", "", "reason for the cancellation, added to the calendar event")
+	undo := flags.Bool("undo", false, "reinstates a cancelled race")
+	flags.Parse(args)
+
+	if len(*raceName) == 0 {
+		log.Fatalf("A race name must be provided with -race")
+	}
+
+	db := progConfig.openDatabase()
+
+	race := findCommandRace(preloadSignups(db), *raceName, *date)
+
+	if *undo {
+		if !race.Cancelled {
+			log.Fatalf("%v is not cancelled", race.Name)
+		}
+		setRaceCancelled(db, race, false, "")
+		log.Printf("Reinstated %v - the form and calendar event will be updated on the next sync\n", race.Name)
+		return
+	}
+
+	if race.Cancelled {
+		log.Fatalf("%v is already cancelled", race.Name)
+	}
+	setRaceCancelled(db, race, true, *reason)
+
+	log.Printf("Cancelled %v on %v - the form and calendar event will be updated on the next sync\n", race.Name, race.Date)
+	for _, signup := range race.Signups {
+		log.Printf("  %v (%v) will be notified on the next sync\n", signup.User.Email, signup.Role)
+	}
+}
+
+// Returns the cancellation emails for everyone signed up for the race
+func cancellationMessages(progConfig ProgramConfig, race *Race) []EmailMessage {
+	messages := []EmailMessage{}
+	for _, signup := range race.Signups {
+		roleLabel := signup.Role
+		if role, exists := progConfig.findRole(signup.Role); exists {
+			roleLabel = role.label()
+		}
+
+		data := newNotificationData(progConfig, signup.User, race, roleLabel, "")
+		data.Reason = race.CancelReason
+		if message, exists := progConfig.newMessage(emailRaceCancelled, data); exists {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func setRaceCancelled(db *gorm.DB, race *Race, cancelled bool, reason string) {
+	err := db.Model(race).Select("Cancelled", "CancelReason", "CancelNotified", "CalendarOutdated").Updates(&Race{
+		Cancelled:        cancelled,
+		CancelReason:     reason,
+		CancelNotified:   false,
+		CalendarOutdated: true,
+	}).Error
+	if err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestCancelledRaceNotifiesAttendees(t *testing.T) {
+	for _, calendarNotifies := range []bool{true, false} {
+		progConfig := ProgramConfig{Roles: []RoleConfig{{Name: "RC", Label: "Race Committee", EntryLimit: 2}}}
+		db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+		calStore := newMemoryCalendarStore()
+		calStore.NoNotify = !calendarNotifies
+		notifier := &memoryNotifier{}
+
+		event, _ := calStore.InsertEvent(&calendar.Event{Summary: "Race 1"})
+		race := &Race{Name: "Race 1", Date: "2030-06-04", EventID: &event.Id}
+		user := &User{Email: "a@example.org", Name: "A"}
+		db.Create(race)
+		db.Create(user)
+		db.Create(&RaceSignup{RaceID: race.ID, UserID: user.ID, Role: "RC"})
+		setRaceCancelled(db, race, true, "High winds")
+
+		updateGoogleCalendar(progConfig, db, calStore, notifier, map[string]*Race{}, false)
+
+		if calendarNotifies && (len(calStore.Notified) != 1 || len(notifier.Sent) != 0) {
+			t.Errorf("expected a calendar notice only, got %v notices and %v emails", len(calStore.Notified), len(notifier.Sent))
+		} else if !calendarNotifies && (len(calStore.Notified) != 0 || len(notifier.Sent) != 1 || notifier.Sent[0].To != "a@example.org") {
+			t.Errorf("expected an email only, got %v notices and emails %v", len(calStore.Notified), notifier.Sent)
+		}
+
+		updated := &Race{}
+		db.First(updated, race.ID)
+		if !updated.CancelNotified {
+			t.Errorf("cancellation not marked as notified")
+		}
+	}
+}
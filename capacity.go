@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	capacitySourceSchedule = "schedule"
+	capacitySourceAdmin    = "admin"
+)
+
+// Sets the entry limit of a role for a single race, promoting users from the
+// waitlist if the limit was raised and marking the calendar event as outdated.
+// Returns the emails for the promoted users, to be sent once the change is saved.
+func setRaceCapacity(db *gorm.DB, progConfig ProgramConfig, race *Race, role string, limit int, source string) []EmailMessage {
+	capacity := &RaceCapacity{}
+	err := db.Where(&RaceCapacity{RaceID: race.ID, Role: role}).FirstOrInit(capacity).Error
+	if err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+
+	capacity.RaceID = race.ID
+	capacity.Role = role
+	capacity.Limit = limit
+	capacity.Source = source
+	db.Save(capacity)
+
+	log.Printf("Set %v capacity for %v to %v\n", role, race.Name, limit)
+	return capacityChanged(db, progConfig, race, role)
+}
+
+// Removes the entry limit override of a role for a single race, returning the emails
+// for the users promoted from the waitlist
+func clearRaceCapacity(db *gorm.DB, progConfig ProgramConfig, race *Race, role string) []EmailMessage {
+	db.Where(&RaceCapacity{RaceID: race.ID, Role: role}).Delete(&RaceCapacity{})
+
+	log.Printf("Cleared %v capacity for %v\n", role, race.Name)
+	return capacityChanged(db, progConfig, race, role)
+}
+
+func capacityChanged(db *gorm.DB, progConfig ProgramConfig, race *Race, role string) []EmailMessage {
+	db.Model(&Race{}).Where("id = ?", race.ID).Update("CalendarOutdated", true)
+
+	messages := []EmailMessage{}
+	formConfig, exists := progConfig.formConfigForRole(role, nil)
+	if !exists {
+		return messages
+	}
+
+	targetRace, err := loadRace(db, race.ID)
+	if err != nil {
+		log.Fatalf("Database error: %v", err)
+	} else if targetRace.Cancelled {
+		return messages
+	}
+
+	promoted, err := formConfig.promoteWaitlist(db, targetRace)
+	if err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+
+	timestamp := time.Now()
+	for _, promotedUser := range promoted {
+		if err := recordSignupEvent(db, formConfig, "capacity", timestamp, promotedUser, targetRace, targetRace.Name, actionPromotion, outcomePromoted); err != nil {
+			log.Fatalf("Unable to record signup event: %v", err)
+		}
+		if message, exists := newSignupMessage(progConfig, formConfig, promotedUser, targetRace, outcomePromoted); exists {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Sets, clears, or lists the per-race capacity overrides
+func runCapacityCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("capacity", flag.ExitOnError)
+	raceName := flags.String("race", "", "name of the race to change - lists all overrides if not provided")
+	date := flags.String("date", "", "date of the race to change, as YYYY-MM-DD - required when more than one race has the name")
+	role := flags.String("role", "", "signup role to change, such as RC or Renters")
+	limit := flags.Int("limit", -1, "new entry limit for the role on the race, or -1 for no limit - required unless -clear is given")
+	clear := flags.Bool("clear", false, "removes the override so the configured limit is used")
+	flags.Parse(args)
+
+	db := progConfig.openDatabase()
+
+	if len(*raceName) == 0 {
+		listRaceCapacities(db)
+		return
+	}
+
+	limitSet := false
+	flags.Visit(func(f *flag.Flag) {
+		limitSet = limitSet || f.Name == "limit"
+	})
+	if !*clear && !limitSet {
+		log.Fatalf("-limit is required unless -clear is given")
+	}
+
+	formConfig, exists := progConfig.formConfigForRole(*role, nil)
+	if !exists {
+		log.Fatalf("Unknown role '%v'", *role)
+	}
+
+	race := findCommandRace(db, *raceName, *date)
+
+	messages := []EmailMessage{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if *clear {
+			messages = clearRaceCapacity(tx, progConfig, race, formConfig.Role)
+		} else {
+			messages = setRaceCapacity(tx, progConfig, race, formConfig.Role, *limit, capacitySourceAdmin)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Unable to update capacity: %v", err)
+	}
+
+	sendMessages(progConfig.newNotifier(), messages)
+}
+
+func listRaceCapacities(db *gorm.DB) {
+	races := []*Race{}
+	if err := db.Preload("Capacities").Order("date").Order("name").Find(&races).Error; err != nil {
+		log.Fatalf("Error getting database races: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tRACE\tROLE\tLIMIT\tSOURCE")
+	for _, race := range races {
+		for _, capacity := range race.Capacities {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", race.Date, race.Name, capacity.Role, capacity.Limit, capacity.Source)
+		}
+	}
+	w.Flush()
+}
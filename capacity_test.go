@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSetRaceCapacityPromotesWaitlistOfSameRace(t *testing.T) {
+	progConfig := ProgramConfig{Roles: []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 1}}}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+
+	// A series of races sharing a name
+	first := &Race{Name: "Wed Night", Date: "2030-06-04"}
+	second := &Race{Name: "Wed Night", Date: "2030-06-11"}
+	db.Create(first)
+	db.Create(second)
+
+	formConfig, _ := progConfig.formConfigForRole("RC", nil)
+	users := []*User{{Email: "a@example.org", Name: "A"}, {Email: "b@example.org", Name: "B"}}
+	for _, user := range users {
+		db.Create(user)
+		race, err := loadRace(db, second.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := formConfig.processAction(db, race, user, actionSignup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := setRaceCapacity(db, progConfig, second, "RC", 2, capacitySourceAdmin)
+	if len(messages) != 1 || messages[0].To != "b@example.org" {
+		t.Errorf("expected a promotion email for b@example.org, got %v", messages)
+	}
+
+	race, err := loadRace(db, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(race.usersFor("RC")) != 2 || len(race.waitlistFor("RC")) != 0 {
+		t.Errorf("expected the waitlist to be promoted, got %v signups and %v waitlisted", len(race.usersFor("RC")), len(race.waitlistFor("RC")))
+	}
+
+	race, err = loadRace(db, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(race.usersFor("RC")) != 0 {
+		t.Errorf("expected no signups on the other race, got %v", len(race.usersFor("RC")))
+	}
+}
+
+func TestFindCommandRaceByDate(t *testing.T) {
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	db.Create(&Race{Name: "Wed Night", Date: "2030-06-04"})
+	db.Create(&Race{Name: "Wed Night", Date: "2030-06-11"})
+	db.Create(&Race{Name: "Regatta", Date: "2030-06-15"})
+
+	if race := findCommandRace(db, "Wed Night", "2030-06-11"); race.Date != "2030-06-11" {
+		t.Errorf("expected the race on 2030-06-11, got %v", race.Date)
+	}
+	if race := findCommandRace(db, "Regatta", ""); race.Date != "2030-06-15" {
+		t.Errorf("expected the only race with the name without a date, got %v", race.Date)
+	}
+}
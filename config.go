@@ -1,37 +1,47 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"path"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/glebarez/sqlite"
-	"golang.org/x/exp/maps"
-	"google.golang.org/api/option"
-	"google.golang.org/api/sheets/v4"
 	"gorm.io/gorm"
 )
 
 type ProgramConfig struct {
 	LastRun              time.Time
 	DataFolder           string
+	Roles                []RoleConfig
+	Forms                []ProgramConfigForm
 	FormRC               ProgramConfigForm
 	FormRentals          ProgramConfigForm
 	CalendarCode         string
+	CalendarBackend      string
+	CalDAV               CalDAVConfig
 	RaceEventDuration    int
 	RaceEventStartOffset int
 	TimeZoneString       string
-	AllowedRentersCount  int
+	AllowedRentersCount  int // Replaced by the role EntryLimit, used for the legacy Renters role
 	AllowedUsersSheetID  string
+	MembershipSourceType string
+	MembershipFile       string
+	MembershipColumns    MembershipColumns
 	RaceLocation         string
 	RentalMembershipYear int
+	SMTP                 SMTPConfig
+	EmailTemplates       map[string]EmailTemplate
+	ReminderDays         []int
+	Organizers           []string
+	OrganizerWebhookURL  string
+	AlertDays            int
+	WebURL               string
+	Duties               DutyConfig
+	Credits              CreditConfig
 }
 
 func (config ProgramConfig) eventDuration() time.Duration {
@@ -66,6 +76,16 @@ func (config ProgramConfig) racesFile() string {
 	return path.Join(config.DataFolder, "races.csv")
 }
 
+// Form responses replayed by -offline runs
+func (config ProgramConfig) offlineResponsesFile() string {
+	return path.Join(config.DataFolder, "offline-responses.csv")
+}
+
+// Membership list used by -offline runs in place of the Google Sheet
+func (config ProgramConfig) offlineMembersFile() string {
+	return path.Join(config.DataFolder, "offline-members.csv")
+}
+
 func (config ProgramConfig) credFile() string {
 	return path.Join(config.DataFolder, "credentials.json")
 }
@@ -75,14 +95,45 @@ func (config ProgramConfig) tokenFile() string {
 }
 
 func (config ProgramConfig) openDatabase() *gorm.DB {
+	return openDatabaseFile(config.dbFile())
+}
+
+// Opens a database that only exists for the lifetime of the program
+func openMemoryDatabase() *gorm.DB {
+	return openDatabaseFile("file::memory:?cache=shared")
+}
+
+func openDatabaseFile(file string) *gorm.DB {
+	// Wait for writes from other processes, such as the server during a sync, instead
+	// of failing straight away because the database is locked
+	separator := "?"
+	if strings.Contains(file, "?") {
+		separator = "&"
+	}
+	dsn := file + separator + "_pragma=busy_timeout(5000)"
+
 	// Connect to the local database
-	db, err := gorm.Open(sqlite.Open(config.dbFile()), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
 	if err != nil {
 		log.Fatalf("Failed to connect database: %v", err)
 	}
 
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&Race{})
+	db.AutoMigrate(&RaceSignup{})
+	migrateRoles(db)
+	db.AutoMigrate(&WaitlistEntry{})
+	db.AutoMigrate(&RaceCapacity{})
+	db.AutoMigrate(&SignupEvent{})
+	db.AutoMigrate(&ProcessedResponse{})
+	db.AutoMigrate(&SentReminder{})
+	db.AutoMigrate(&RaceMinimum{})
+	db.AutoMigrate(&SentAlert{})
+	db.AutoMigrate(&Boat{})
+	db.AutoMigrate(&LotteryRequest{})
+	db.AutoMigrate(&LotteryDraw{})
+	db.AutoMigrate(&CreditEntry{})
+	db.AutoMigrate(&WebSignupRequest{})
 
 	return db
 }
@@ -115,59 +166,61 @@ func (config ProgramConfig) writeConfig(file string) {
 }
 
 type UserEntry struct {
-	Email string
-	Name  string
+	Email   string
+	Name    string
+	Type    string
+	Expires time.Time
 }
 
-func (config ProgramConfig) getValidSheetEmails(ctx context.Context, client *http.Client) []UserEntry {
-	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Sheets client: %v", err)
-	}
+type ProgramConfigForm struct {
+	FormCode         string
+	Role             string
+	TableName        string // Replaced by Role, used by FormRC and FormRentals
+	PrelookupDays    int
+	EntryLimit       int // Replaced by the role EntryLimit, used by FormRC
+	Minimum          int // Replaced by the role Minimum, used by FormRC and FormRentals
+	MembershipTypes  []string
+	ValidThroughRace bool
+	LotteryDrawHours int
+}
 
-	readRange := "A:C"
-	resp, err := srv.Spreadsheets.Values.Get(config.AllowedUsersSheetID, readRange).Do()
-	if err != nil {
-		log.Fatalf("Unable to retrieve data from sheet: %v", err)
+func (form ProgramConfigForm) roleName() string {
+	if len(form.Role) > 0 {
+		return form.Role
 	}
+	return form.TableName
+}
 
-	users := map[string]UserEntry{}
-
-	for _, row := range resp.Values {
-		emails := strings.ToLower(strings.TrimSpace(row[0].(string)))
-		name := strings.TrimSpace(row[1].(string))
-		membership_year, err := strconv.Atoi(strings.TrimSpace(row[2].(string)))
-		if err != nil {
-			log.Fatalf("Unable to convert membership year for %v", row)
+// Returns the configured forms, gated on the given user list
+func (config ProgramConfig) formConfigs(users *[]UserEntry) []FormConfig {
+	forms := []FormConfig{}
+	for _, f := range config.forms() {
+		role, exists := config.findRole(f.roleName())
+		if !exists {
+			log.Fatalf("Form %v uses unknown role '%v'", f.FormCode, f.roleName())
 		}
+		forms = append(forms, f.toFormConfig(role, users).withDuties(config.dutiesFor(role.Name)).withCredits(config.creditsFor(role.Name)).withTimeZone(config.timezone()))
+	}
+	return forms
+}
 
-		if membership_year < config.RentalMembershipYear {
-			log.Printf("Skipping %v due to membership year %v < %v", name, membership_year, config.RentalMembershipYear)
-			continue
-		}
+// Returns the form config for the given role, using the settings of the first form
+// bound to the role if there is one
+func (config ProgramConfig) formConfigForRole(roleName string, users *[]UserEntry) (FormConfig, bool) {
+	role, exists := config.findRole(roleName)
+	if !exists {
+		return FormConfig{}, false
+	}
 
-		for _, email := range strings.Split(emails, ";") {
-			if len(email) == 0 || len(name) == 0 {
-				log.Printf("User field empty for email '%v', '%v'", email, name)
-				continue
-			} else if _, exists := users[email]; !exists {
-				users[email] = UserEntry{email, name}
-			} else {
-				log.Printf("Duplicate entry for email '%v' detected as '%v'", email, name)
-			}
+	for _, f := range config.formConfigs(users) {
+		if f.Role == role.Name {
+			return f, true
 		}
 	}
 
-	return maps.Values(users)
-}
-
-type ProgramConfigForm struct {
-	FormCode      string
-	TableName     string
-	PrelookupDays int
-	EntryLimit    int
+	return ProgramConfigForm{Role: role.Name}.toFormConfig(role, users).withDuties(config.dutiesFor(role.Name)).withCredits(config.creditsFor(role.Name)).withTimeZone(config.timezone()), true
 }
 
-func (form ProgramConfigForm) toFormConfig(users *[]UserEntry) FormConfig {
-	return newFormConfig(form.FormCode, form.TableName).withLookupDays(form.PrelookupDays).withEntryLimit(form.EntryLimit).withValidUserList(users)
+func (form ProgramConfigForm) toFormConfig(role RoleConfig, users *[]UserEntry) FormConfig {
+	return newFormConfig(form.FormCode, role.Name).withLabel(role.label()).withLookupDays(form.PrelookupDays).withEntryLimit(role.EntryLimit).withValidUserList(users).withMembershipRules(form.MembershipTypes, form.ValidThroughRace).withBoats(role.Boats).withQuotas(role.MaxPerSeason, role.MaxConcurrent).withLottery(form.LotteryDrawHours)
 }
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	creditEarn   = "earn"
+	creditSpend  = "spend"
+	creditAdjust = "adjust"
+
+	outcomeRejectedCredits = "rejected-credits"
+)
+
+// CreditConfig defines the credits members earn for each completed duty in EarnRole, such
+// as RC, and spend on races in SpendRoles, such as rentals. With Required set, signups for
+// the spend roles need enough credits. With Priority set, lottery requests from members with
+// enough credits are drawn ahead of the rest, and only those races spend credits. Races before
+// Since, given as YYYY-MM-DD, neither earn nor spend credits, so that credits can be enabled
+// on a database that already has past races.
+type CreditConfig struct {
+	EarnRole    string
+	EarnPerDuty int
+	SpendRoles  []string
+	CostPerRace int
+	Required    bool
+	Priority    bool
+	Since       string
+}
+
+// CreditEntry is an entry in the credit ledger of a user. Earned and adjusted credits are
+// positive and spent credits are negative. RaceID is zero for adjustments.
+type CreditEntry struct {
+	gorm.Model
+	UserID uint
+	User   *User
+	RaceID uint
+	Role   string
+	Kind   string
+	Amount int
+	Note   string
+}
+
+func (credits CreditConfig) enabled() bool {
+	return len(credits.EarnRole) > 0 && credits.EarnPerDuty > 0
+}
+
+// Returns the configured credits with EarnRole and SpendRoles matching the names of the
+// configured roles, so that signups can be compared to them exactly
+func (config ProgramConfig) creditConfig() CreditConfig {
+	credits := config.Credits
+	if !credits.enabled() {
+		return credits
+	}
+
+	earnRole, exists := config.findRole(credits.EarnRole)
+	if !exists {
+		log.Fatalf("Credits use unknown role '%v'", credits.EarnRole)
+	}
+	credits.EarnRole = earnRole.Name
+
+	spendRoles := []string{}
+	for _, name := range credits.SpendRoles {
+		spendRole, exists := config.findRole(name)
+		if !exists {
+			log.Fatalf("Credits use unknown role '%v'", name)
+		}
+		spendRoles = append(spendRoles, spendRole.Name)
+	}
+	credits.SpendRoles = spendRoles
+
+	if len(credits.Since) > 0 {
+		if _, err := time.Parse(time.DateOnly, credits.Since); err != nil {
+			log.Fatalf("Invalid credits start date '%v', expected YYYY-MM-DD: %v", credits.Since, err)
+		}
+	}
+	return credits
+}
+
+// Returns the credits that are spent on signups for the role, or nil if the role does not spend credits
+func (config ProgramConfig) creditsFor(role string) *CreditConfig {
+	credits := config.creditConfig()
+	if !credits.enabled() || credits.CostPerRace <= 0 || (!credits.Required && !credits.Priority) {
+		return nil
+	}
+
+	for _, spendRole := range credits.SpendRoles {
+		if strings.EqualFold(spendRole, role) {
+			return &credits
+		}
+	}
+	return nil
+}
+
+// Returns true if the race is on or after the Since date, so that it earns or spends credits
+func (credits CreditConfig) appliesTo(race *Race) bool {
+	return race.Date >= credits.Since
+}
+
+// Returns the sum of the ledger entries of the user
+func creditBalance(db *gorm.DB, userID uint) (int, error) {
+	var balance int
+	err := db.Model(&CreditEntry{}).Where("user_id = ?", userID).Select("COALESCE(SUM(amount), 0)").Scan(&balance).Error
+	return balance, err
+}
+
+// Returns true if the user's signup for the race in the role is paid for with credits
+func (credits CreditConfig) charges(db *gorm.DB, role string, userID uint, raceID uint) (bool, error) {
+	if credits.Required {
+		return true, nil
+	}
+
+	var count int64
+	err := db.Model(&LotteryRequest{}).Where("race_id = ? AND user_id = ? AND role = ? AND priority = ?", raceID, userID, role, true).Count(&count).Error
+	return count > 0, err
+}
+
+// Returns the balance of the user less the credits held for the upcoming signups and
+// waitlist entries in the role that will be charged once the races are complete
+func (credits CreditConfig) available(db *gorm.DB, role string, userID uint, today string) (int, error) {
+	races, err := memberRaces(db, role, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	held := 0
+	for _, race := range races {
+		if race.Date < today || !credits.appliesTo(race) {
+			continue
+		} else if charged, err := credits.charges(db, role, userID, race.ID); err != nil {
+			return 0, err
+		} else if charged {
+			held += credits.CostPerRace
+		}
+	}
+
+	balance, err := creditBalance(db, userID)
+	return balance - held, err
+}
+
+// Returns true if credits are required for signups on the form and the user does not have
+// enough available credits for the race. Races the user is already on never need more credits.
+func (config FormConfig) lacksCredits(db *gorm.DB, race *Race, user *User, currentTime time.Time) (bool, error) {
+	if config.Credits == nil || !config.Credits.Required || !config.Credits.appliesTo(race) {
+		return false, nil
+	}
+
+	races, err := memberRaces(db, config.Role, user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range races {
+		if r.ID == race.ID {
+			return false, nil
+		}
+	}
+
+	available, err := config.Credits.available(db, config.Role, user.ID, currentTime.Format(time.DateOnly))
+	if err != nil {
+		return false, err
+	} else if available < config.Credits.CostPerRace {
+		log.Printf("%v has %v of the %v credits needed for %v\n", user.Email, available, config.Credits.CostPerRace, race.Name)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Returns the signups of the roles on races from the since date until before today that have
+// not been cancelled and that have no ledger entry of the given kind
+func unrecordedSignups(db *gorm.DB, roles []string, kind string, since string, today string) []*RaceSignup {
+	signups := []*RaceSignup{}
+	err := db.Joins("JOIN races ON races.id = race_signups.race_id AND races.deleted_at IS NULL").
+		Where("race_signups.role IN ?", roles).
+		Where("races.cancelled = ? AND races.date >= ? AND races.date < ?", false, since, today).
+		Where("NOT EXISTS (?)", db.Model(&CreditEntry{}).Select("1").
+			Where("credit_entries.user_id = race_signups.user_id AND credit_entries.race_id = race_signups.race_id").
+			Where("credit_entries.role = race_signups.role AND credit_entries.kind = ?", kind)).
+		Order("races.date").Order("race_signups.id").Find(&signups).Error
+	if err != nil {
+		log.Fatalf("Error getting database signups: %v", err)
+	}
+	return signups
+}
+
+// Adds the credits earned for completed duties and spent on completed races to the ledger.
+// Each signup is only recorded once, so the ledger can be updated on every sync.
+func updateCredits(db *gorm.DB, progConfig ProgramConfig, currentTime time.Time) {
+	credits := progConfig.creditConfig()
+	if !credits.enabled() {
+		return
+	}
+
+	today := currentTime.In(progConfig.timezone()).Format(time.DateOnly)
+
+	for _, signup := range unrecordedSignups(db, []string{credits.EarnRole}, creditEarn, credits.Since, today) {
+		entry := &CreditEntry{UserID: signup.UserID, RaceID: signup.RaceID, Role: signup.Role, Kind: creditEarn, Amount: credits.EarnPerDuty}
+		if err := db.Create(entry).Error; err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+	}
+
+	if credits.CostPerRace <= 0 || len(credits.SpendRoles) == 0 {
+		return
+	}
+
+	for _, signup := range unrecordedSignups(db, credits.SpendRoles, creditSpend, credits.Since, today) {
+		if charged, err := credits.charges(db, signup.Role, signup.UserID, signup.RaceID); err != nil {
+			log.Fatalf("Database error: %v", err)
+		} else if !charged {
+			continue
+		}
+
+		entry := &CreditEntry{UserID: signup.UserID, RaceID: signup.RaceID, Role: signup.Role, Kind: creditSpend, Amount: -credits.CostPerRace}
+		if err := db.Create(entry).Error; err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+	}
+}
+
+// Lists the credit balance of each member, or the ledger of a member, and adds adjustments
+func runCreditsCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("credits", flag.ExitOnError)
+	email := flags.String("email", "", "email of the member to show the ledger for - lists all balances if not provided")
+	adjust := flags.Int("adjust", 0, "credits to add to the member, or remove if negative")
+	note := flags.String("note", "", "reason for the adjustment")
+	flags.Parse(args)
+
+	db := progConfig.openDatabase()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	if len(*email) == 0 {
+		if *adjust != 0 {
+			log.Fatalf("An email is required to adjust credits")
+		}
+
+		users := []*User{}
+		err := db.Where("id IN (?)", db.Model(&CreditEntry{}).Select("user_id")).Order("email").Find(&users).Error
+		if err != nil {
+			log.Fatalf("Error getting database users: %v", err)
+		}
+
+		fmt.Fprintln(w, "EMAIL\tNAME\tBALANCE")
+		for _, user := range users {
+			balance, err := creditBalance(db, user.ID)
+			if err != nil {
+				log.Fatalf("Database error: %v", err)
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", user.Email, user.Name, balance)
+		}
+		w.Flush()
+		return
+	}
+
+	user := &User{}
+	err := db.Where(&User{Email: strings.ToLower(strings.TrimSpace(*email))}).First(user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Fatalf("No user found with email '%v'", *email)
+	} else if err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+
+	if *adjust != 0 {
+		entry := &CreditEntry{UserID: user.ID, Kind: creditAdjust, Amount: *adjust, Note: *note}
+		if err := db.Create(entry).Error; err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+		log.Printf("Adjusted the credits of %v by %v\n", user.Email, *adjust)
+	}
+
+	entries := []*CreditEntry{}
+	if err := db.Where(&CreditEntry{UserID: user.ID}).Order("created_at").Order("id").Find(&entries).Error; err != nil {
+		log.Fatalf("Error getting database credit entries: %v", err)
+	}
+
+	fmt.Fprintln(w, "DATE\tKIND\tRACE\tROLE\tAMOUNT\tBALANCE\tNOTE")
+	balance := 0
+	for _, entry := range entries {
+		raceName := ""
+		if entry.RaceID != 0 {
+			race := &Race{}
+			db.Unscoped().First(race, entry.RaceID)
+			raceName = race.Name
+		}
+
+		balance += entry.Amount
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%+d\t%v\t%v\n", entry.CreatedAt.Format(time.DateOnly), entry.Kind, raceName, entry.Role, entry.Amount, balance, entry.Note)
+	}
+	w.Flush()
+}
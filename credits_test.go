@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateCreditsMatchesRolesIgnoringCase(t *testing.T) {
+	progConfig := ProgramConfig{
+		Roles:   []RoleConfig{{Name: "RC", EntryLimit: 2}, {Name: "Renters", EntryLimit: 2}},
+		Credits: CreditConfig{EarnRole: "rc", EarnPerDuty: 2, SpendRoles: []string{"renters"}, CostPerRace: 1, Required: true},
+	}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+
+	duty := &Race{Name: "Duty", Date: "2025-05-01"}
+	rental := &Race{Name: "Rental", Date: "2025-05-08"}
+	db.Create(duty)
+	db.Create(rental)
+	db.Create(&RaceSignup{RaceID: duty.ID, UserID: user.ID, Role: "RC"})
+	db.Create(&RaceSignup{RaceID: rental.ID, UserID: user.ID, Role: "Renters"})
+
+	updateCredits(db, progConfig, time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	balance, err := creditBalance(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 1 {
+		t.Errorf("expected a balance of 1 after earning 2 and spending 1, got %v", balance)
+	}
+}
+
+func TestCreditsSinceIgnoresEarlierRaces(t *testing.T) {
+	progConfig := ProgramConfig{
+		Roles:   []RoleConfig{{Name: "RC", EntryLimit: 2}, {Name: "Renters", EntryLimit: 2}},
+		Credits: CreditConfig{EarnRole: "RC", EarnPerDuty: 1, SpendRoles: []string{"Renters"}, CostPerRace: 1, Required: true, Since: "2025-06-01"},
+	}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+
+	// History from before credits were enabled
+	for _, date := range []string{"2024-07-01", "2025-05-01", "2025-05-15"} {
+		rental := &Race{Name: "Rental " + date, Date: date}
+		db.Create(rental)
+		db.Create(&RaceSignup{RaceID: rental.ID, UserID: user.ID, Role: "Renters"})
+	}
+	oldDuty := &Race{Name: "Old Duty", Date: "2025-05-20"}
+	duty := &Race{Name: "Duty", Date: "2025-06-01"}
+	rental := &Race{Name: "Rental", Date: "2025-06-08"}
+	db.Create(oldDuty)
+	db.Create(duty)
+	db.Create(rental)
+	db.Create(&RaceSignup{RaceID: oldDuty.ID, UserID: user.ID, Role: "RC"})
+	db.Create(&RaceSignup{RaceID: duty.ID, UserID: user.ID, Role: "RC"})
+	db.Create(&RaceSignup{RaceID: rental.ID, UserID: user.ID, Role: "Renters"})
+
+	updateCredits(db, progConfig, time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC))
+
+	entries := []*CreditEntry{}
+	db.Order("id").Find(&entries)
+	if len(entries) != 2 || entries[0].RaceID != duty.ID || entries[1].RaceID != rental.ID {
+		t.Fatalf("expected only the duty and rental from the start date to be recorded, got %+v", entries)
+	}
+	if balance, _ := creditBalance(db, user.ID); balance != 0 {
+		t.Errorf("expected a balance of 0 after earning 1 and spending 1, got %v", balance)
+	}
+}
+
+func TestCreditsSinceInTheFuture(t *testing.T) {
+	progConfig := ProgramConfig{
+		Roles:   []RoleConfig{{Name: "Renters", EntryLimit: 2}, {Name: "RC", EntryLimit: 2}},
+		Credits: CreditConfig{EarnRole: "RC", EarnPerDuty: 1, SpendRoles: []string{"Renters"}, CostPerRace: 1, Required: true, Since: "2031-01-01"},
+	}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+
+	users := []UserEntry{{Email: "a@example.org", Name: "A"}}
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+
+	signedUp := &Race{Name: "Rental 1", Date: "2030-07-01"}
+	before := &Race{Name: "Rental 2", Date: "2030-07-08"}
+	after := &Race{Name: "Rental 3", Date: "2031-07-01"}
+	db.Create(signedUp)
+	db.Create(before)
+	db.Create(after)
+	db.Create(&RaceSignup{RaceID: signedUp.ID, UserID: user.ID, Role: "Renters"})
+	db.Create(&CreditEntry{UserID: user.ID, Kind: creditAdjust, Amount: 1})
+
+	config, _ := progConfig.formConfigForRole("Renters", &users)
+	currentTime := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	if available, err := config.Credits.available(db, "Renters", user.ID, "2030-06-01"); err != nil || available != 1 {
+		t.Errorf("expected no credits to be held for races before the start date, got %v, %v", available, err)
+	}
+	if lacks, err := config.lacksCredits(db, before, user, currentTime); err != nil || lacks {
+		t.Errorf("expected races before the start date to not need credits, got %v, %v", lacks, err)
+	}
+
+	db.Model(&CreditEntry{}).Where("user_id = ?", user.ID).Update("Amount", 0)
+	if lacks, err := config.lacksCredits(db, after, user, currentTime); err != nil || !lacks {
+		t.Errorf("expected races from the start date to need credits, got %v, %v", lacks, err)
+	}
+}
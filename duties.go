@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DutyConfig defines the duties, such as RC, that members owe each season. Members with
+// unmet duties may be kept from signing up for the gated roles for races on or after the
+// cutoff date, given as MM-DD within the season.
+type DutyConfig struct {
+	Role            string
+	PerSeason       int
+	MembershipTypes []string
+	GatedRoles      []string
+	Cutoff          string
+}
+
+// dutyStatus is the duties of a member for a season
+type dutyStatus struct {
+	Served   int
+	SignedUp int
+	Owed     int
+}
+
+func (duties DutyConfig) enabled() bool {
+	return len(duties.Role) > 0 && duties.PerSeason > 0
+}
+
+// Returns true if members of the membership type owe duties
+func (duties DutyConfig) appliesTo(membershipType string) bool {
+	return len(duties.MembershipTypes) == 0 || slices.ContainsFunc(duties.MembershipTypes, func(t string) bool {
+		return strings.EqualFold(t, membershipType)
+	})
+}
+
+// Returns the configured duties with Role matching the name of the configured role, so
+// that signups can be compared to it exactly
+func (config ProgramConfig) dutyConfig() DutyConfig {
+	duties := config.Duties
+	if !duties.enabled() {
+		return duties
+	}
+
+	role, exists := config.findRole(duties.Role)
+	if !exists {
+		log.Fatalf("Duties use unknown role '%v'", duties.Role)
+	}
+	duties.Role = role.Name
+
+	if len(duties.Cutoff) > 0 {
+		if _, err := time.Parse("01-02", duties.Cutoff); err != nil {
+			log.Fatalf("Invalid duty cutoff '%v', expected MM-DD: %v", duties.Cutoff, err)
+		}
+	}
+	return duties
+}
+
+// Returns the duties that gate signups for the role, or nil if the role is not gated
+func (config ProgramConfig) dutiesFor(role string) *DutyConfig {
+	duties := config.dutyConfig()
+	if !duties.enabled() || len(duties.Cutoff) == 0 {
+		return nil
+	}
+
+	for _, gatedRole := range duties.GatedRoles {
+		if strings.EqualFold(gatedRole, role) {
+			return &duties
+		}
+	}
+	return nil
+}
+
+// Returns the number of duties the user has served before today and is signed up for
+// from today onwards in the season, not counting cancelled races
+func (duties DutyConfig) status(db *gorm.DB, userID uint, season string, today string) (dutyStatus, error) {
+	dates := []string{}
+	err := db.Model(&RaceSignup{}).
+		Joins("JOIN races ON races.id = race_signups.race_id AND races.deleted_at IS NULL").
+		Where("race_signups.user_id = ? AND race_signups.role = ?", userID, duties.Role).
+		Where("races.cancelled = ? AND races.date LIKE ?", false, season+"-%").
+		Pluck("races.date", &dates).Error
+	if err != nil {
+		return dutyStatus{}, err
+	}
+
+	status := dutyStatus{}
+	for _, date := range dates {
+		if date < today {
+			status.Served += 1
+		} else {
+			status.SignedUp += 1
+		}
+	}
+	status.Owed = max(duties.PerSeason-status.Served-status.SignedUp, 0)
+	return status, nil
+}
+
+// Returns true if the signup is for a gated role on a race on or after the cutoff, and the
+// user owes duties for the season of the race. Duties the user is signed up for count as met.
+func (config FormConfig) owesDuties(db *gorm.DB, race *Race, user *User, currentTime time.Time) (bool, error) {
+	if config.Duties == nil || config.ValidUserList == nil {
+		return false, nil
+	}
+
+	season := raceSeason(race)
+	if race.Date < fmt.Sprintf("%v-%v", season, config.Duties.Cutoff) {
+		return false, nil
+	}
+
+	for _, member := range *config.ValidUserList {
+		if !strings.EqualFold(member.Email, user.Email) {
+			continue
+		} else if !config.Duties.appliesTo(member.Type) {
+			return false, nil
+		}
+
+		status, err := config.Duties.status(db, user.ID, season, currentTime.Format(time.DateOnly))
+		if err != nil {
+			return false, err
+		} else if status.Owed > 0 {
+			log.Printf("%v owes %v %v duties for %v\n", user.Email, status.Owed, config.Duties.Role, season)
+			return true, nil
+		}
+		return false, nil
+	}
+
+	return false, nil
+}
+
+// Prints the duties served, signed up for, and owed by each member who owes duties
+func runDutiesCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("duties", flag.ExitOnError)
+	season := flags.String("season", fmt.Sprint(time.Now().Year()), "season to report duties for")
+	owing := flags.Bool("owing", false, "only list members who owe duties")
+	flags.Parse(args)
+
+	duties := progConfig.dutyConfig()
+	if !duties.enabled() {
+		log.Fatalf("No duties are configured")
+	}
+
+	members, err := progConfig.getValidSheetEmails(progConfig.openMembershipSource())
+	if err != nil {
+		log.Fatalf("Unable to read membership list: %v", err)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Email < members[j].Email
+	})
+
+	db := progConfig.openDatabase()
+	today := time.Now().In(progConfig.timezone()).Format(time.DateOnly)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EMAIL\tNAME\tTYPE\tSERVED\tSIGNED UP\tOWED")
+	totalOwed := 0
+	for _, member := range members {
+		if !duties.appliesTo(member.Type) {
+			continue
+		}
+
+		status := dutyStatus{Owed: duties.PerSeason}
+		user := &User{}
+		err := db.Where(&User{Email: member.Email}).Limit(1).Find(user).Error
+		if err != nil {
+			log.Fatalf("Database error: %v", err)
+		} else if user.ID != 0 {
+			if status, err = duties.status(db, user.ID, *season, today); err != nil {
+				log.Fatalf("Database error: %v", err)
+			}
+		}
+
+		totalOwed += status.Owed
+		if *owing && status.Owed == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", member.Email, member.Name, member.Type, status.Served, status.SignedUp, status.Owed)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%v %v duties owed for %v\n", totalOwed, duties.Role, *season)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDutiesMatchRoleIgnoringCase(t *testing.T) {
+	progConfig := ProgramConfig{
+		Roles:  []RoleConfig{{Name: "RC", EntryLimit: 2}, {Name: "Renters", EntryLimit: 2}},
+		Duties: DutyConfig{Role: "rc", PerSeason: 1, GatedRoles: []string{"renters"}, Cutoff: "06-01"},
+	}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+
+	users := []UserEntry{{Email: "a@example.org", Name: "A"}}
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+
+	duty := &Race{Name: "Duty", Date: "2030-05-01"}
+	rental := &Race{Name: "Rental", Date: "2030-07-01"}
+	db.Create(duty)
+	db.Create(rental)
+
+	rcConfig, _ := progConfig.formConfigForRole("RC", &users)
+	race, err := loadRace(db, duty.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := rcConfig.processAction(db, race, user, actionSignup); err != nil || result.Outcome != outcomeAccepted {
+		t.Fatalf("RC signup returned %v, %v", result.Outcome, err)
+	}
+
+	rentalConfig, _ := progConfig.formConfigForRole("Renters", &users)
+	race, err = loadRace(db, rental.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := rentalConfig.processAction(db, race, user, actionSignup); err != nil || result.Outcome != outcomeAccepted {
+		t.Errorf("rental signup after signing up for duties returned %v, %v", result.Outcome, err)
+	}
+}
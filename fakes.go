@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/forms/v1"
+)
+
+// In-memory implementations of the sync services, used to run a full sync
+// without access to the Google APIs
+
+func newMemoryServices() SyncServices {
+	return SyncServices{
+		Forms:      newMemoryFormStore(),
+		Calendar:   newMemoryCalendarStore(),
+		Membership: &memoryMembershipSource{},
+		Notifier:   &memoryNotifier{},
+	}
+}
+
+type memoryFormStore struct {
+	Forms     map[string]*forms.Form
+	Responses map[string][]*forms.FormResponse
+}
+
+func newMemoryFormStore() *memoryFormStore {
+	return &memoryFormStore{
+		Forms:     map[string]*forms.Form{},
+		Responses: map[string][]*forms.FormResponse{},
+	}
+}
+
+// Adds a form with the name, action, and race date questions expected by the sync
+func (store *memoryFormStore) addSignupForm(formCode string, title string) *forms.Form {
+	newQuestion := func(title string, id string, choice bool) *forms.Item {
+		question := &forms.Question{QuestionId: id}
+		if choice {
+			question.ChoiceQuestion = &forms.ChoiceQuestion{Type: "CHECKBOX"}
+		} else {
+			question.TextQuestion = &forms.TextQuestion{}
+		}
+		return &forms.Item{
+			Title:        title,
+			QuestionItem: &forms.QuestionItem{Question: question},
+		}
+	}
+
+	form := &forms.Form{
+		FormId: formCode,
+		Info:   &forms.Info{Title: title},
+		Items: []*forms.Item{
+			newQuestion("Name", "name", false),
+			newQuestion("Action", "action", false),
+			newQuestion("Race Dates", "races", true),
+		},
+	}
+
+	store.Forms[formCode] = form
+	return form
+}
+
+// Adds a response to a form created with addSignupForm
+func (store *memoryFormStore) addResponse(formCode string, email string, name string, action string, races ...string) *forms.FormResponse {
+	textAnswer := func(id string, values ...string) *forms.Answer {
+		answers := []*forms.TextAnswer{}
+		for _, v := range values {
+			answers = append(answers, &forms.TextAnswer{Value: v})
+		}
+		return &forms.Answer{QuestionId: id, TextAnswers: &forms.TextAnswers{Answers: answers}}
+	}
+
+	// Timestamps have fixed millisecond precision like the Forms API, and are spaced so that
+	// responses added in the same millisecond still sort in the order they were added
+	timestamp := time.Now().UTC().Add(time.Duration(len(store.Responses[formCode])) * time.Millisecond).Format("2006-01-02T15:04:05.000Z07:00")
+	response := &forms.FormResponse{
+		FormId:            formCode,
+		ResponseId:        fmt.Sprintf("%s-%d", formCode, len(store.Responses[formCode])+1),
+		RespondentEmail:   email,
+		CreateTime:        timestamp,
+		LastSubmittedTime: timestamp,
+		Answers: map[string]forms.Answer{
+			"name":   *textAnswer("name", name),
+			"action": *textAnswer("action", action),
+			"races":  *textAnswer("races", races...),
+		},
+	}
+
+	store.Responses[formCode] = append(store.Responses[formCode], response)
+	return response
+}
+
+// Adds the responses in a CSV file with the form code, email, name, action, and races
+// separated by ";" in each row after the header, returning the number of responses added
+func (store *memoryFormStore) loadResponses(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %v: %v", file, err)
+	}
+
+	count := 0
+	for i, row := range rows {
+		if i == 0 || len(strings.TrimSpace(strings.Join(row, ""))) == 0 {
+			continue
+		} else if len(row) < 5 {
+			return count, fmt.Errorf("row %v of %v has %v columns, expected 5", i+1, file, len(row))
+		}
+
+		formCode := strings.TrimSpace(row[0])
+		if _, exists := store.Forms[formCode]; !exists {
+			return count, fmt.Errorf("row %v of %v uses unknown form '%v'", i+1, file, formCode)
+		}
+
+		races := []string{}
+		for _, race := range strings.Split(row[4], ";") {
+			if race = strings.TrimSpace(race); len(race) > 0 {
+				races = append(races, race)
+			}
+		}
+		store.addResponse(formCode, strings.TrimSpace(row[1]), strings.TrimSpace(row[2]), strings.TrimSpace(row[3]), races...)
+		count += 1
+	}
+	return count, nil
+}
+
+func (store *memoryFormStore) GetForm(formCode string) (*forms.Form, error) {
+	form, exists := store.Forms[formCode]
+	if !exists {
+		return nil, fmt.Errorf("form %v not found", formCode)
+	}
+	return form, nil
+}
+
+func (store *memoryFormStore) ListResponses(formCode string) ([]*forms.FormResponse, error) {
+	if _, exists := store.Forms[formCode]; !exists {
+		return nil, fmt.Errorf("form %v not found", formCode)
+	}
+	return slices.Clone(store.Responses[formCode]), nil
+}
+
+func (store *memoryFormStore) UpdateItem(formCode string, item *forms.Item, index int64) error {
+	form, exists := store.Forms[formCode]
+	if !exists {
+		return fmt.Errorf("form %v not found", formCode)
+	} else if index < 0 || index >= int64(len(form.Items)) {
+		return fmt.Errorf("item index %v out of range for form %v", index, formCode)
+	}
+
+	form.Items[index] = item
+	return nil
+}
+
+type memoryCalendarStore struct {
+	Events   map[string]*calendar.Event
+	Notified []string
+	NoNotify bool // acts like a backend that cannot notify attendees, such as CalDAV
+	nextID   int
+}
+
+func newMemoryCalendarStore() *memoryCalendarStore {
+	return &memoryCalendarStore{Events: map[string]*calendar.Event{}}
+}
+
+func (store *memoryCalendarStore) GetEvent(eventID string) (*calendar.Event, error) {
+	event, exists := store.Events[eventID]
+	if !exists {
+		return nil, fmt.Errorf("event %v not found", eventID)
+	}
+
+	eventCopy := *event
+	return &eventCopy, nil
+}
+
+func (store *memoryCalendarStore) InsertEvent(event *calendar.Event) (*calendar.Event, error) {
+	store.nextID += 1
+
+	eventCopy := *event
+	eventCopy.Id = fmt.Sprintf("event-%d", store.nextID)
+	store.Events[eventCopy.Id] = &eventCopy
+
+	result := eventCopy
+	return &result, nil
+}
+
+func (store *memoryCalendarStore) DeleteEvent(eventID string) error {
+	if _, exists := store.Events[eventID]; !exists {
+		return fmt.Errorf("event %v not found", eventID)
+	}
+
+	if !store.NoNotify {
+		store.Notified = append(store.Notified, eventID)
+	}
+
+	delete(store.Events, eventID)
+	return nil
+}
+
+func (store *memoryCalendarStore) UpdateEvent(eventID string, event *calendar.Event, notify bool) (*calendar.Event, error) {
+	if _, exists := store.Events[eventID]; !exists {
+		return nil, fmt.Errorf("event %v not found", eventID)
+	}
+
+	if notify {
+		store.Notified = append(store.Notified, eventID)
+	}
+
+	eventCopy := *event
+	eventCopy.Id = eventID
+	store.Events[eventID] = &eventCopy
+
+	result := eventCopy
+	return &result, nil
+}
+
+func (store *memoryCalendarStore) NotifiesAttendees() bool {
+	return !store.NoNotify
+}
+
+// Returns the stored events sorted by identifier
+func (store *memoryCalendarStore) sortedEvents() []*calendar.Event {
+	ids := []string{}
+	for id := range store.Events {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	events := []*calendar.Event{}
+	for _, id := range ids {
+		events = append(events, store.Events[id])
+	}
+	return events
+}
+
+// memoryNotifier keeps the sent messages and writes them to the log
+type memoryNotifier struct {
+	Sent []EmailMessage
+}
+
+func (notifier *memoryNotifier) Send(message EmailMessage) error {
+	notifier.Sent = append(notifier.Sent, message)
+	log.Printf("Email to %v: %v\n", message.To, message.Subject)
+	return nil
+}
+
+type memoryMembershipSource struct {
+	Rows [][]string
+}
+
+func (source *memoryMembershipSource) MembershipRows() ([][]string, error) {
+	return source.Rows, nil
+}
@@ -1,27 +1,51 @@
 package main
 
 import (
-	"log"
-	"reflect"
+	"slices"
 	"strings"
 	"time"
 )
 
 type FormConfig struct {
 	FormCode           string
-	TableName          string
+	Role               string
+	Label              string
 	ShowEntryTimeLimit *time.Duration
 	ValidUserList      *[]UserEntry
 	EntryLimit         int
+	MembershipTypes    []string
+	ValidThroughRace   bool
+	Boats              bool
+	MaxPerSeason       int
+	MaxConcurrent      int
+	LotteryDrawHours   int
+	Duties             *DutyConfig
+	Credits            *CreditConfig
+	TimeZone           *time.Location
 }
 
-func newFormConfig(form string, tableName string) FormConfig {
+func newFormConfig(form string, role string) FormConfig {
 	return FormConfig{
 		FormCode:           form,
-		TableName:          tableName,
+		Role:               role,
+		Label:              role,
 		ShowEntryTimeLimit: nil,
 		ValidUserList:      nil,
-		EntryLimit:         -1}
+		EntryLimit:         -1,
+		MembershipTypes:    nil,
+		ValidThroughRace:   false,
+		Boats:              false,
+		MaxPerSeason:       0,
+		MaxConcurrent:      0,
+		LotteryDrawHours:   0,
+		Duties:             nil,
+		Credits:            nil,
+		TimeZone:           time.Local}
+}
+
+func (config FormConfig) withLabel(label string) FormConfig {
+	config.Label = label
+	return config
 }
 
 func (config FormConfig) withEntryLimit(entryLimit int) FormConfig {
@@ -46,27 +70,89 @@ func (config FormConfig) withValidUserList(userList *[]UserEntry) FormConfig {
 	return config
 }
 
-func (config FormConfig) getUserTable(race *Race) *[]*User {
-	intf := reflect.Indirect(reflect.ValueOf(race))
+// Limits signups to members with one of the membership types, if any are given, and to
+// memberships that are valid through the race date if validThroughRace is set
+func (config FormConfig) withMembershipRules(membershipTypes []string, validThroughRace bool) FormConfig {
+	config.MembershipTypes = membershipTypes
+	config.ValidThroughRace = validThroughRace
+	return config
+}
 
-	val := intf.FieldByName(config.TableName)
-	if val.Type() != reflect.TypeOf([]*User{}) {
-		log.Fatalf("field '%v' not found with valid type - %v", config.TableName, val)
-	}
+// Assigns a rental boat to each user signed up through the form
+func (config FormConfig) withBoats(boats bool) FormConfig {
+	config.Boats = boats
+	return config
+}
+
+// Limits the number of races each member may sign up for in a season and at once, with
+// zero for no limit
+func (config FormConfig) withQuotas(maxPerSeason int, maxConcurrent int) FormConfig {
+	config.MaxPerSeason = maxPerSeason
+	config.MaxConcurrent = maxConcurrent
+	return config
+}
+
+// Collects signups as lottery requests until the given number of hours before each race,
+// with zero for first come, first served signups
+func (config FormConfig) withLottery(drawHours int) FormConfig {
+	config.LotteryDrawHours = drawHours
+	return config
+}
+
+// Keeps members who owe duties from signing up for races on or after the duty cutoff
+func (config FormConfig) withDuties(duties *DutyConfig) FormConfig {
+	config.Duties = duties
+	return config
+}
+
+// Charges credits for signups on the form, requiring them or using them for lottery priority
+func (config FormConfig) withCredits(credits *CreditConfig) FormConfig {
+	config.Credits = credits
+	return config
+}
+
+// Sets the time zone that signup limits use to find the current date
+func (config FormConfig) withTimeZone(loc *time.Location) FormConfig {
+	config.TimeZone = loc
+	return config
+}
 
-	return val.Addr().Interface().(*[]*User)
+// Returns the current time in the time zone of the form
+func (config FormConfig) now() time.Time {
+	return time.Now().In(config.TimeZone)
 }
 
-func (config FormConfig) canPerformActionForUser(user *User) bool {
+func (config FormConfig) getUsers(race *Race) []*User {
+	return race.usersFor(config.Role)
+}
+
+// Checks that the user is on the membership list and, for signups, that their membership
+// meets the form's membership rules for the race, returning the rejection outcome if not.
+// Cancellations are allowed for any member so that they can always leave a race.
+func (config FormConfig) canPerformActionForUser(user *User, race *Race, action string) (bool, string) {
 	if config.ValidUserList == nil {
-		return true
+		return true, ""
 	}
 
 	for _, validUser := range *config.ValidUserList {
-		if strings.EqualFold(user.Email, validUser.Email) {
-			return true
+		if !strings.EqualFold(user.Email, validUser.Email) {
+			continue
+		} else if action != actionSignup {
+			return true, ""
+		}
+
+		if len(config.MembershipTypes) > 0 && !slices.ContainsFunc(config.MembershipTypes, func(t string) bool {
+			return strings.EqualFold(t, validUser.Type)
+		}) {
+			return false, outcomeRejectedMembership
 		}
+
+		if config.ValidThroughRace && !validUser.Expires.IsZero() && validUser.Expires.Before(race.Time(validUser.Expires.Location())) {
+			return false, outcomeRejectedMembership
+		}
+
+		return true, ""
 	}
 
-	return false
+	return false, outcomeRejectedNotMember
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/forms/v1"
+	"gorm.io/gorm"
+)
+
+// SignupEvent records the outcome of each signup action processed for a race
+type SignupEvent struct {
+	gorm.Model
+	ResponseID string
+	Email      string
+	RaceID     *uint
+	RaceName   string
+	Role       string
+	Action     string
+	Timestamp  time.Time
+	Outcome    string
+}
+
+// Returns the time a form response was last submitted
+func responseTimestamp(response *forms.FormResponse) time.Time {
+	timestamp, err := time.Parse(time.RFC3339Nano, response.LastSubmittedTime)
+	if err != nil {
+		return time.Now()
+	}
+	return timestamp
+}
+
+// Records the outcome of a signup request for a single race - the race is nil if it could not be found
+func recordSignupEvent(db *gorm.DB, formConfig FormConfig, responseID string, timestamp time.Time, user *User, race *Race, raceName string, action string, outcome string) error {
+	event := &SignupEvent{
+		ResponseID: responseID,
+		Email:      user.Email,
+		RaceName:   raceName,
+		Role:       formConfig.Role,
+		Action:     action,
+		Timestamp:  timestamp,
+		Outcome:    outcome,
+	}
+
+	if race != nil {
+		event.RaceID = &race.ID
+		event.RaceName = race.Name
+	}
+
+	return db.Create(event).Error
+}
+
+// Prints the signup history, optionally filtered by email and race
+func runHistoryCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	email := flags.String("email", "", "only show events for the given email")
+	raceName := flags.String("race", "", "only show events for the given race name")
+	limit := flags.Int("limit", 50, "maximum number of events to show, or -1 for all")
+	flags.Parse(args)
+
+	db := progConfig.openDatabase()
+
+	query := db.Order("timestamp desc").Order("id desc").Limit(*limit)
+	if len(*email) > 0 {
+		query = query.Where("email = ?", strings.ToLower(strings.TrimSpace(*email)))
+	}
+	if len(*raceName) > 0 {
+		query = query.Where("race_name = ?", *raceName)
+	}
+
+	events := []*SignupEvent{}
+	if err := query.Find(&events).Error; err != nil {
+		log.Fatalf("Unable to read signup history: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tEMAIL\tRACE\tROLE\tACTION\tOUTCOME\tRESPONSE")
+	for _, e := range events {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", e.Timestamp.In(progConfig.timezone()).Format(time.DateTime), e.Email, e.RaceName, e.Role, e.Action, e.Outcome, e.ResponseID)
+	}
+	w.Flush()
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"gorm.io/gorm"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// Writes the races as an RFC 5545 calendar. Events only list the names of those signed
+// up, so that the feeds can be shared without exposing member emails, and the public
+// club feed only lists first names as on the roster page.
+func writeICS(w io.Writer, progConfig ProgramConfig, name string, races []*Race, public bool) error {
+	displayName := func(u *User) string { return u.Name }
+	if public {
+		displayName = func(u *User) string { return firstName(u.Name) }
+	}
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//SailingSignup//Race Schedule//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icsEscape(name),
+	}
+
+	stamp := time.Now().UTC().Format(icsTimeFormat)
+	for _, race := range races {
+		lines = append(lines, raceEventLines(progConfig, race, stamp, displayName)...)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, icsFold(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the VEVENT lines for a race, using the race ID for a UID that stays the same
+// when the race is moved or renamed
+func raceEventLines(progConfig ProgramConfig, race *Race, stamp string, displayName func(*User) string) []string {
+	start := race.startTime(progConfig)
+
+	event := &calendar.Event{
+		ICalUID:     fmt.Sprintf("race-%d@sailingsignup", race.ID),
+		Start:       &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:         &calendar.EventDateTime{DateTime: start.Add(race.duration(progConfig)).Format(time.RFC3339)},
+		Summary:     race.calendarSummary(),
+		Description: race.describeSignups(progConfig, displayName),
+		Location:    race.location(progConfig),
+		Status:      "confirmed",
+	}
+	if race.Cancelled {
+		event.Status = "cancelled"
+	}
+
+	return eventLines(event, stamp)
+}
+
+// Returns the VEVENT lines for a calendar event, including any attendees
+func eventLines(event *calendar.Event, stamp string) []string {
+	lines := []string{
+		"BEGIN:VEVENT",
+		"UID:" + event.ICalUID,
+		"DTSTAMP:" + stamp,
+	}
+
+	if event.Start != nil {
+		lines = append(lines, "DTSTART:"+icsTime(event.Start.DateTime))
+	}
+	if event.End != nil {
+		lines = append(lines, "DTEND:"+icsTime(event.End.DateTime))
+	}
+
+	lines = append(lines, "SUMMARY:"+icsEscape(event.Summary))
+	if len(event.Description) > 0 {
+		lines = append(lines, "DESCRIPTION:"+icsEscape(event.Description))
+	}
+	if len(event.Location) > 0 {
+		lines = append(lines, "LOCATION:"+icsEscape(event.Location))
+	}
+	if len(event.Status) > 0 {
+		lines = append(lines, "STATUS:"+strings.ToUpper(event.Status))
+	}
+
+	for _, attendee := range event.Attendees {
+		lines = append(lines, fmt.Sprintf("ATTENDEE;CN=%v;ROLE=REQ-PARTICIPANT:mailto:%v", icsParam(attendee.DisplayName), attendee.Email))
+	}
+
+	return append(lines, "END:VEVENT")
+}
+
+// Converts an RFC 3339 time to an RFC 5545 UTC time
+func icsTime(dateTime string) string {
+	t, err := time.Parse(time.RFC3339, dateTime)
+	if err != nil {
+		log.Fatalf("Invalid event time '%v': %v", dateTime, err)
+	}
+	return t.UTC().Format(icsTimeFormat)
+}
+
+// Quotes a parameter value, which may not contain double quotes
+func icsParam(value string) string {
+	return fmt.Sprintf("\"%v\"", strings.ReplaceAll(value, "\"", "'"))
+}
+
+// Escapes a text value as required by RFC 5545
+func icsEscape(text string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\",
+		";", "\\;",
+		",", "\\,",
+		"\r\n", "\\n",
+		"\n", "\\n",
+	).Replace(text)
+}
+
+// Folds a content line to at most 75 octets per line, without splitting UTF-8 characters
+func icsFold(line string) string {
+	var folded strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	folded.WriteString("\r\n")
+	return folded.String()
+}
+
+// Returns the URL of the calendar feed served for the token, or "-" if WebURL is not set
+func (config ProgramConfig) feedURL(token string) string {
+	if len(config.WebURL) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%v/calendar/%v.ics", strings.TrimSuffix(config.WebURL, "/"), token)
+}
+
+// Returns the secret token used in the URL of the user's calendar feed, creating it if needed
+func ensureFeedToken(db *gorm.DB, user *User) string {
+	if len(user.FeedToken) > 0 {
+		return user.FeedToken
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		log.Fatalf("Unable to create feed token: %v", err)
+	}
+
+	user.FeedToken = hex.EncodeToString(token)
+	if err := db.Model(user).Update("FeedToken", user.FeedToken).Error; err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+	return user.FeedToken
+}
+
+// Returns every race for the club feed
+func clubFeedRaces(db *gorm.DB) ([]*Race, error) {
+	races := []*Race{}
+	err := preloadSignups(preloadRaceDetails(db)).Order("date").Order("name").Find(&races).Error
+	return races, err
+}
+
+// Returns the user with the feed token and the races they are signed up for in any role
+func userFeedRaces(db *gorm.DB, token string) (*User, []*Race, error) {
+	if len(token) == 0 {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	user := &User{}
+	if err := db.Where(&User{FeedToken: token}).First(user).Error; err != nil {
+		return nil, nil, err
+	}
+
+	races := []*Race{}
+	err := preloadSignups(preloadRaceDetails(db)).
+		Where("id IN (?)", db.Model(&RaceSignup{}).Select("race_id").Where("user_id = ?", user.ID)).
+		Order("date").Order("name").Find(&races).Error
+	return user, races, err
+}
+
+func writeICSFile(file string, progConfig ProgramConfig, name string, races []*Race, public bool) {
+	f, err := os.Create(file)
+	if err != nil {
+		log.Fatalf("Unable to create %v: %v", file, err)
+	}
+	defer f.Close()
+
+	if err := writeICS(f, progConfig, name, races, public); err != nil {
+		log.Fatalf("Unable to write %v: %v", file, err)
+	}
+}
+
+func (config ProgramConfig) feedFolder() string {
+	return path.Join(config.DataFolder, "ics")
+}
+
+// Writes the club feed and a feed for each user with a signup to the ics folder in the
+// data folder, and lists the feed file for each user
+func runExportICSCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("export-ics", flag.ExitOnError)
+	flags.Parse(args)
+
+	db := progConfig.openDatabase()
+
+	if err := os.MkdirAll(progConfig.feedFolder(), 0755); err != nil {
+		log.Fatalf("Unable to create %v: %v", progConfig.feedFolder(), err)
+	}
+
+	races, err := clubFeedRaces(db)
+	if err != nil {
+		log.Fatalf("Error getting database races: %v", err)
+	}
+	writeICSFile(path.Join(progConfig.feedFolder(), "club.ics"), progConfig, "Race Schedule", races, true)
+
+	users := []*User{}
+	err = db.Where("id IN (?)", db.Model(&RaceSignup{}).Select("user_id")).Order("email").Find(&users).Error
+	if err != nil {
+		log.Fatalf("Error getting database users: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EMAIL\tFILE\tURL")
+	for _, user := range users {
+		token := ensureFeedToken(db, user)
+
+		_, userRaces, err := userFeedRaces(db, token)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Fatalf("Error getting database races: %v", err)
+		}
+
+		file := path.Join(progConfig.feedFolder(), token+".ics")
+		writeICSFile(file, progConfig, fmt.Sprintf("Race Schedule for %v", user.Name), userRaces, false)
+		fmt.Fprintf(w, "%v\t%v\t%v\n", user.Email, file, progConfig.feedURL(token))
+	}
+	w.Flush()
+}
+
+func (server *rosterServer) handleClubFeed(w http.ResponseWriter, r *http.Request) {
+	races, err := clubFeedRaces(server.db)
+	if err != nil {
+		log.Printf("Error getting database races: %v", err)
+		http.Error(w, "unable to load races", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := writeICS(w, server.progConfig, "Race Schedule", races, true); err != nil {
+		log.Printf("Unable to write club feed: %v", err)
+	}
+}
+
+func (server *rosterServer) handleUserFeed(w http.ResponseWriter, r *http.Request) {
+	token, found := strings.CutSuffix(r.PathValue("file"), ".ics")
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, races, err := userFeedRaces(server.db, token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Error getting database races: %v", err)
+		http.Error(w, "unable to load races", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := writeICS(w, server.progConfig, fmt.Sprintf("Race Schedule for %v", user.Name), races, false); err != nil {
+		log.Printf("Unable to write feed for %v: %v", user.Email, err)
+	}
+}
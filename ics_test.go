@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICSFold(t *testing.T) {
+	for _, line := range []string{
+		"SUMMARY:" + strings.Repeat("x", 150),
+		"DESCRIPTION:" + strings.Repeat("é", 80),
+		"DESCRIPTION:" + strings.Repeat("⛵", 60),
+		"SUMMARY:Short",
+	} {
+		folded := icsFold(line)
+		if !strings.HasSuffix(folded, "\r\n") {
+			t.Fatalf("expected the folded line to end with CRLF, got %q", folded)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n")
+		for i, l := range lines {
+			if len(l) > 75 {
+				t.Errorf("line %v of %q is %v octets", i, line[:20], len(l))
+			} else if !utf8.ValidString(l) {
+				t.Errorf("line %v of %q splits a character: %q", i, line[:20], l)
+			} else if i > 0 && !strings.HasPrefix(l, " ") {
+				t.Errorf("expected continuation line %v to start with a space, got %q", i, l)
+			}
+		}
+
+		if unfolded := strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", ""); unfolded != line {
+			t.Errorf("expected unfolding to give back %q, got %q", line, unfolded)
+		}
+	}
+
+	if folded := icsFold(strings.Repeat("x", 75)); strings.Count(folded, "\r\n") != 1 {
+		t.Errorf("expected a 75 octet line to fit on one line, got %q", folded)
+	}
+}
+
+func TestICSEscape(t *testing.T) {
+	escaped := icsEscape("Race 1; Spring, Series\\A\nat the dock\r\nbring lunch")
+	if expected := `Race 1\; Spring\, Series\\A\nat the dock\nbring lunch`; escaped != expected {
+		t.Errorf("expected %q, got %q", expected, escaped)
+	}
+}
+
+func TestFeedURL(t *testing.T) {
+	config := ProgramConfig{WebURL: "https://signup.example.org/"}
+	if url := config.feedURL("abc"); url != "https://signup.example.org/calendar/abc.ics" {
+		t.Errorf("unexpected feed URL %v", url)
+	}
+	if url := (ProgramConfig{}).feedURL("abc"); url != "-" {
+		t.Errorf("expected no feed URL without WebURL, got %v", url)
+	}
+}
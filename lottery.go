@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	mathrand "math/rand"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	actionLottery         = "lottery"
+	outcomeLotteryEntered = "lottery-entered"
+
+	emailLotteryAccepted   = "lottery-accepted"
+	emailLotteryWaitlisted = "lottery-waitlisted"
+)
+
+// LotteryRequest is a signup for a lottery race that waits for the draw. Once drawn,
+// it records the position, weight, and outcome of the request.
+type LotteryRequest struct {
+	gorm.Model
+	RaceID   uint
+	UserID   uint
+	User     *User
+	Role     string
+	Position int
+	Weight   float64
+	Priority bool
+	Outcome  string
+}
+
+// LotteryDraw records the draw for a race and role, along with the seed of the random
+// order so that the draw can be checked
+type LotteryDraw struct {
+	gorm.Model
+	RaceID   uint
+	Role     string
+	Seed     int64
+	Entrants int
+	DrawnAt  time.Time
+}
+
+// Returns the time after which the lottery for the race is drawn
+func (config FormConfig) lotteryDrawTime(progConfig ProgramConfig, race *Race) time.Time {
+	return race.startTime(progConfig).Add(-time.Duration(config.LotteryDrawHours) * time.Hour)
+}
+
+// Returns true if the form is a lottery form and the lottery for the race has not been drawn
+func (config FormConfig) lotteryPending(db *gorm.DB, race *Race) (bool, error) {
+	if config.LotteryDrawHours <= 0 {
+		return false, nil
+	}
+
+	var count int64
+	err := db.Model(&LotteryDraw{}).Where(&LotteryDraw{RaceID: race.ID, Role: config.Role}).Count(&count).Error
+	return count == 0, err
+}
+
+// Returns the undrawn lottery requests for the race in request order
+func (config FormConfig) lotteryRequests(db *gorm.DB, race *Race) ([]*LotteryRequest, error) {
+	requests := []*LotteryRequest{}
+	err := db.Preload("User").Where(&LotteryRequest{RaceID: race.ID, Role: config.Role}).Where("position = ?", 0).Order("id").Find(&requests).Error
+	return requests, err
+}
+
+// Enters the user in the lottery for the race, or removes their request when they cancel
+func (config FormConfig) applyLotteryRequest(db *gorm.DB, race *Race, user *User, action string) (SignupResult, error) {
+	requests, err := config.lotteryRequests(db, race)
+	if err != nil {
+		return SignupResult{}, err
+	}
+
+	var existing *LotteryRequest = nil
+	for _, request := range requests {
+		if request.UserID == user.ID {
+			existing = request
+		}
+	}
+
+	if action == actionCancel {
+		if existing != nil {
+			if err := db.Delete(existing).Error; err != nil {
+				return SignupResult{}, err
+			}
+		}
+		return config.applyAction(db, race, user, action)
+	}
+
+	if existing == nil {
+		if err := db.Omit("User").Create(&LotteryRequest{RaceID: race.ID, UserID: user.ID, Role: config.Role}).Error; err != nil {
+			return SignupResult{}, err
+		}
+	}
+	return SignupResult{Outcome: outcomeLotteryEntered, Promoted: []*User{}}, nil
+}
+
+// Returns the number of races earlier in the season that the user was signed up for in the role
+func pastAllocations(db *gorm.DB, role string, userID uint, race *Race) int {
+	var count int64
+	err := db.Model(&RaceSignup{}).
+		Joins("JOIN races ON races.id = race_signups.race_id AND races.deleted_at IS NULL").
+		Where("race_signups.user_id = ? AND race_signups.role = ?", userID, role).
+		Where("races.cancelled = ? AND races.date < ? AND races.date LIKE ?", false, race.Date, raceSeason(race)+"-%").
+		Count(&count).Error
+	if err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+	return int(count)
+}
+
+// Returns a random seed for a draw
+func newLotterySeed() int64 {
+	var seed int64
+	if err := binary.Read(rand.Reader, binary.LittleEndian, &seed); err != nil {
+		log.Fatalf("Unable to create lottery seed: %v", err)
+	}
+	return seed
+}
+
+// Orders the requests by descending u^(1/weight) for a uniform random u drawn from the seed,
+// with priority requests first, so that the order only depends on the seed, the weights, and
+// the request order
+func lotteryOrder(requests []*LotteryRequest, seed int64) []*LotteryRequest {
+	rng := mathrand.New(mathrand.NewSource(seed))
+
+	keys := map[uint]float64{}
+	for _, request := range requests {
+		keys[request.ID] = math.Pow(rng.Float64(), 1/request.Weight)
+	}
+
+	ordered := append([]*LotteryRequest{}, requests...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority
+		}
+		return keys[ordered[i].ID] > keys[ordered[j].ID]
+	})
+	return ordered
+}
+
+// Draws the lottery for a race loaded with loadRace. Requests are weighted by one over one
+// plus the number of earlier races in the season the member was signed up for, and members
+// with enough credits for a priority request are drawn first. The requests are then
+// signed up in the drawn order so that the first requests fill the open spaces and the rest
+// are added to the waitlist. Returns the drawn requests in order.
+func (config FormConfig) drawLottery(db *gorm.DB, race *Race, currentTime time.Time) ([]*LotteryRequest, error) {
+	requests, err := config.lotteryRequests(db, race)
+	if err != nil {
+		return nil, err
+	}
+
+	draw := &LotteryDraw{RaceID: race.ID, Role: config.Role, Seed: newLotterySeed(), Entrants: len(requests), DrawnAt: currentTime}
+	if err := db.Create(draw).Error; err != nil {
+		return nil, err
+	}
+
+	for _, request := range requests {
+		request.Weight = 1 / float64(1+pastAllocations(db, config.Role, request.UserID, race))
+		if config.Credits != nil && config.Credits.Priority && config.Credits.appliesTo(race) {
+			available, err := config.Credits.available(db, config.Role, request.UserID, currentTime.Format(time.DateOnly))
+			if err != nil {
+				return nil, err
+			}
+			request.Priority = available >= config.Credits.CostPerRace
+		}
+	}
+
+	ordered := lotteryOrder(requests, draw.Seed)
+	for i, request := range ordered {
+		result, err := config.processAction(db, race, request.User, actionSignup)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Position = i + 1
+		request.Outcome = result.Outcome
+		if err := db.Omit("User").Save(request).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if len(requests) > 0 {
+		log.Printf("Drew the %v lottery for %v with seed %v: %v requests\n", config.Role, race.Name, draw.Seed, len(requests))
+	}
+	return ordered, nil
+}
+
+// Draws the lotteries of the form whose draw time has passed, recording and emailing
+// the outcome for each request
+func drawLotteries(progConfig ProgramConfig, formConfig FormConfig, db *gorm.DB, services SyncServices, updatedRaces *map[string]*Race, currentTime time.Time) {
+	if formConfig.LotteryDrawHours <= 0 {
+		return
+	}
+
+	for _, race := range getAllRaces(db) {
+		if race.Cancelled || currentTime.Before(formConfig.lotteryDrawTime(progConfig, race)) {
+			continue
+		}
+
+		pending, err := formConfig.lotteryPending(db, race)
+		if err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+		requests, err := formConfig.lotteryRequests(db, race)
+		if err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+
+		if !pending {
+			continue
+		} else if !race.startTime(progConfig).After(currentTime) && len(requests) == 0 {
+			// Races that have already started only need a draw if requests are waiting
+			continue
+		}
+
+		messages := []EmailMessage{}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			targetRace, err := loadRace(tx, race.ID)
+			if err != nil {
+				return err
+			}
+
+			drawn, err := formConfig.drawLottery(tx, targetRace, currentTime)
+			if err != nil {
+				return err
+			} else if len(drawn) > 0 {
+				if err := tx.Model(&Race{}).Where("id = ?", targetRace.ID).Update("CalendarOutdated", true).Error; err != nil {
+					return err
+				}
+			}
+			for _, request := range drawn {
+				if err := recordSignupEvent(tx, formConfig, fmt.Sprintf("lottery-%d", race.ID), currentTime, request.User, targetRace, targetRace.Name, actionLottery, request.Outcome); err != nil {
+					return err
+				}
+				services.Plan.recordResponse(formConfig.Role, request.User.Email, fmt.Sprintf("%v (%v)", actionLottery, request.Outcome), targetRace.Name)
+
+				template := request.Outcome
+				if request.Outcome == outcomeAccepted {
+					template = emailLotteryAccepted
+				} else if request.Outcome == outcomeWaitlisted {
+					template = emailLotteryWaitlisted
+				}
+				if message, exists := progConfig.newMessage(template, newSignupData(progConfig, formConfig, request.User, targetRace, request.Outcome)); exists {
+					messages = append(messages, message)
+				}
+			}
+
+			if updatedRaces != nil {
+				(*updatedRaces)[targetRace.Name] = targetRace
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Unable to draw lottery for %v: %v", race.Name, err)
+		}
+
+		sendMessages(services.Notifier, messages)
+	}
+}
+
+// Lists the lottery draws, or the drawn order of the requests for a race
+func runLotteryCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("lottery", flag.ExitOnError)
+	raceName := flags.String("race", "", "name of the race to show the drawn order for - lists all draws if not provided")
+	date := flags.String("date", "", "date of the race, as YYYY-MM-DD - required when more than one race has the name")
+	flags.Parse(args)
+
+	db := progConfig.openDatabase()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	if len(*raceName) == 0 {
+		draws := []*LotteryDraw{}
+		if err := db.Order("drawn_at").Find(&draws).Error; err != nil {
+			log.Fatalf("Error getting database draws: %v", err)
+		}
+
+		fmt.Fprintln(w, "DRAWN\tRACE\tDATE\tROLE\tENTRANTS\tSEED")
+		for _, draw := range draws {
+			race := &Race{}
+			db.Unscoped().First(race, draw.RaceID)
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", draw.DrawnAt.Format(time.DateTime), race.Name, race.Date, draw.Role, draw.Entrants, draw.Seed)
+		}
+		w.Flush()
+		return
+	}
+
+	race := findCommandRace(db, *raceName, *date)
+
+	requests := []*LotteryRequest{}
+	if err := db.Preload("User").Where(&LotteryRequest{RaceID: race.ID}).Order("role").Order("position").Order("id").Find(&requests).Error; err != nil {
+		log.Fatalf("Error getting database lottery requests: %v", err)
+	}
+
+	fmt.Fprintln(w, "ROLE\tPOSITION\tEMAIL\tWEIGHT\tPRIORITY\tOUTCOME")
+	for _, request := range requests {
+		position, outcome := fmt.Sprint(request.Position), request.Outcome
+		if request.Position == 0 {
+			position, outcome = "-", "not drawn"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.3f\t%v\t%v\n", request.Role, position, request.User.Email, request.Weight, request.Priority, outcome)
+	}
+	w.Flush()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDrawLotteriesDrawsRaceByID(t *testing.T) {
+	progConfig := ProgramConfig{Roles: []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 1}}}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	services := newMemoryServices()
+
+	// A series of races sharing a name, where the later race was created first
+	later := &Race{Name: "Wed Night", Date: "2030-06-18", StartTime: "18:00"}
+	earlier := &Race{Name: "Wed Night", Date: "2030-06-11", StartTime: "18:00"}
+	db.Create(later)
+	db.Create(earlier)
+
+	formConfig, _ := progConfig.formConfigForRole("RC", nil)
+	formConfig = formConfig.withLottery(48)
+
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+	race, err := loadRace(db, earlier.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := formConfig.processAction(db, race, user, actionSignup); err != nil {
+		t.Fatal(err)
+	} else if result.Outcome != outcomeLotteryEntered {
+		t.Fatalf("expected a lottery request, got %v", result.Outcome)
+	}
+
+	// Only the earlier race is past its draw time
+	currentTime := time.Date(2030, 6, 10, 12, 0, 0, 0, progConfig.timezone())
+	drawLotteries(progConfig, formConfig, db, services, nil, currentTime)
+
+	for _, raceID := range []uint{earlier.ID, later.ID} {
+		race, err := loadRace(db, raceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, err := formConfig.lotteryPending(db, race)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drawn := raceID == earlier.ID; pending == drawn {
+			t.Errorf("race on %v: expected drawn %v, got pending %v", race.Date, drawn, pending)
+		}
+		if signedUp := raceID == earlier.ID; (len(race.usersFor("RC")) == 1) != signedUp {
+			t.Errorf("race on %v: expected signed up %v, got %v signups", race.Date, signedUp, len(race.usersFor("RC")))
+		}
+	}
+
+	notifier := services.Notifier.(*memoryNotifier)
+	if len(notifier.Sent) != 1 || notifier.Sent[0].To != "a@example.org" {
+		t.Errorf("expected a lottery email for a@example.org, got %v", notifier.Sent)
+	}
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"regexp"
 	"slices"
 	"strings"
 	"time"
@@ -15,7 +14,6 @@ import (
 	"golang.org/x/exp/maps"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/forms/v1"
-	"google.golang.org/api/option"
 	"gorm.io/gorm"
 )
 
@@ -28,10 +26,92 @@ func main() {
 	log.SetOutput(os.Stdout)
 
 	forceCalendarUpdate := flag.Bool("force", false, "forces the calendar to update")
+	offline := flag.Bool("offline", false, "runs the sync against in-memory services instead of Google")
+	dryRun := flag.Bool("dry-run", false, "prints the changes the sync would make without applying them")
 	flag.Parse()
 
 	initTime := time.Now()
 	configFile := "config.json"
+	progConfig := loadConfig(configFile)
+
+	switch command := flag.Arg(0); command {
+	case "", "sync":
+	case "history":
+		runHistoryCommand(progConfig, flag.Args()[1:])
+		return
+	case "serve":
+		runServeCommand(progConfig, flag.Args()[1:])
+		return
+	case "capacity":
+		runCapacityCommand(progConfig, flag.Args()[1:])
+		return
+	case "cancel-race":
+		runCancelRaceCommand(progConfig, flag.Args()[1:])
+		return
+	case "export-ics":
+		runExportICSCommand(progConfig, flag.Args()[1:])
+		return
+	case "boats":
+		runBoatsCommand(progConfig, flag.Args()[1:])
+		return
+	case "usage":
+		runUsageCommand(progConfig, flag.Args()[1:])
+		return
+	case "lottery":
+		runLotteryCommand(progConfig, flag.Args()[1:])
+		return
+	case "duties":
+		runDutiesCommand(progConfig, flag.Args()[1:])
+		return
+	case "credits":
+		runCreditsCommand(progConfig, flag.Args()[1:])
+		return
+	default:
+		log.Fatalf("Unknown command %v", command)
+	}
+
+	log.Printf("Last Run: %s\n", progConfig.LastRun.Format(time.DateTime))
+
+	// Connect to the local database and create the services used to access the
+	// forms, calendar, and membership list
+	var db *gorm.DB
+	var services SyncServices
+	if *offline {
+		db = openMemoryDatabase()
+		services = newOfflineServices(progConfig)
+	} else {
+		db = progConfig.openDatabase()
+		services = newGoogleServices(progConfig)
+	}
+
+	if *dryRun {
+		// Run the sync inside a transaction that is always rolled back so that
+		// no database changes are kept
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		planServices := services.withPlan()
+		runSync(progConfig, tx, planServices, *forceCalendarUpdate)
+		planServices.Plan.write(os.Stdout)
+		return
+	}
+
+	runSync(progConfig, db, services, *forceCalendarUpdate)
+
+	if *offline {
+		for _, event := range services.Calendar.(*memoryCalendarStore).sortedEvents() {
+			log.Printf("Offline calendar event %v: %v\n%v\n", event.Id, event.Summary, event.Description)
+		}
+		return
+	}
+
+	// Update the program time and save the resulting config file
+	progConfig.LastRun = initTime
+	progConfig.writeConfig(configFile)
+}
+
+// Reads the program config, writing a new config file if none exists
+func loadConfig(configFile string) ProgramConfig {
 	progConfig, err := readConfig(configFile)
 	if err != nil {
 		if _, errf := os.Stat(configFile); errf == nil {
@@ -41,19 +121,57 @@ func main() {
 			log.Fatalf("Unable to read config file %v - new config file written", err)
 		}
 	}
-	log.Printf("Last Run: %s\n", progConfig.LastRun.Format(time.DateTime))
+	return progConfig
+}
+
+// Creates in-memory services with a signup form for each configured form, holding the
+// responses in the offline responses file if there is one
+func newOfflineServices(progConfig ProgramConfig) SyncServices {
+	services := newMemoryServices()
+	formStore := services.Forms.(*memoryFormStore)
+	for _, f := range progConfig.forms() {
+		if len(f.FormCode) > 0 {
+			formStore.addSignupForm(f.FormCode, f.roleName())
+		}
+	}
+
+	if count, err := formStore.loadResponses(progConfig.offlineResponsesFile()); errors.Is(err, os.ErrNotExist) {
+		log.Printf("No %v found, the offline forms have no responses\n", progConfig.offlineResponsesFile())
+	} else if err != nil {
+		log.Fatalf("Unable to read offline responses: %v", err)
+	} else {
+		log.Printf("Loaded %v offline responses\n", count)
+	}
 
-	// Connect to the local database
-	db := progConfig.openDatabase()
+	// Local membership files are read as they would be for a full sync, and the Google
+	// Sheet is replaced by the offline members file
+	if source := progConfig.newFileMembershipSource(); source != nil {
+		services.Membership = source
+	} else if _, err := os.Stat(progConfig.offlineMembersFile()); err == nil {
+		services.Membership = &csvMembershipSource{file: progConfig.offlineMembersFile()}
+	} else {
+		log.Printf("No %v found, every offline signup will be rejected as a non-member\n", progConfig.offlineMembersFile())
+	}
+	return services
+}
 
-	// Create new race events as necessary from CSV
-	createNewRaceEventsFromCSV(db, progConfig)
+// Runs a full sync of the race schedule, form responses, form options, and calendar events
+func runSync(progConfig ProgramConfig, db *gorm.DB, services SyncServices, forceCalendarUpdate bool) {
+	// Add, move, and remove race events to match the CSV
+	sendMessages(services.Notifier, reconcileRaceSchedule(db, progConfig, services.Calendar))
 
-	// Create the Google API Context
-	ctx, client := getGoogleContext(progConfig)
+	// Match the rental capacity of upcoming races to the active boats
+	sendMessages(services.Notifier, updateBoatCapacities(db, progConfig, time.Now()))
 
-	// Update the forms and calendar items
-	validEmailList := progConfig.getValidSheetEmails(ctx, client)
+	// Record the credits earned and spent on completed races before any signups are checked
+	updateCredits(db, progConfig, time.Now())
+
+	// Update the forms and calendar items, stopping if the membership list cannot be read
+	// rather than rejecting every signup
+	validEmailList, err := progConfig.getValidSheetEmails(services.Membership)
+	if err != nil {
+		log.Fatalf("Unable to read membership list: %v", err)
+	}
 
 	// Create users, and ensure that the name matches the spreadsheet if provided
 	for _, user := range validEmailList {
@@ -80,24 +198,23 @@ func main() {
 		log.Printf("Found Email %v - %v", email.Email, email.Name)
 	}
 
-	forms := []FormConfig{
-		progConfig.FormRC.toFormConfig(&validEmailList),
-		progConfig.FormRentals.toFormConfig(&validEmailList),
-	}
+	forms := progConfig.formConfigs(&validEmailList)
 
 	updatedRaces := map[string]*Race{}
 
 	for _, f := range forms {
 		if len(f.FormCode) > 0 {
-			updateGoogleForm(progConfig, f, db, ctx, client, &updatedRaces)
+			updateGoogleForm(progConfig, f, db, services, &updatedRaces)
+		} else {
+			drawLotteries(progConfig, f, db, services, &updatedRaces, time.Now())
 		}
 	}
 
-	updateGoogleCalendar(progConfig, db, ctx, client, updatedRaces, *forceCalendarUpdate)
+	sendUnderstaffedAlerts(progConfig, db, services, time.Now())
 
-	// Update the program time and save the resulting config file
-	progConfig.LastRun = initTime
-	progConfig.writeConfig(configFile)
+	updateGoogleCalendar(progConfig, db, services.Calendar, services.Notifier, updatedRaces, forceCalendarUpdate)
+
+	sendReminders(progConfig, db, services.Notifier, time.Now())
 }
 
 func cmpResponse(a, b *forms.FormResponse) int {
@@ -110,40 +227,56 @@ func cmpResponse(a, b *forms.FormResponse) int {
 	}
 }
 
-func createNewRaceEventsFromCSV(db *gorm.DB, config ProgramConfig) {
-	var races = readRaceEvents(config.racesFile())
-	for _, r := range races {
-		var testRace Race
-		err := db.Where(&Race{Name: r.Name, Date: r.Date}).First(&testRace).Error
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				log.Printf("Record not found for %s\n", r.Name)
-				db.Create(r)
-			} else {
-				log.Fatalf("Database error: %v", err)
-			}
-		}
-	}
-}
-
 func getAllRaces(db *gorm.DB) []*Race {
 	// Get all the races
 	allRaces := []*Race{}
-	result := db.Preload("RC").Preload("Renters").Find(&allRaces)
+	result := preloadSignups(preloadRaceDetails(db)).Order("date").Order("name").Find(&allRaces)
 	if result.Error != nil {
 		log.Fatalf("Error getting database races: %v", result.Error)
 	}
 	return allRaces
 }
 
-func updateGoogleForm(progConfig ProgramConfig, formConfig FormConfig, db *gorm.DB, ctx context.Context, client *http.Client, updatedRaces *map[string]*Race) {
-	// Create the forms service to update the form with new races
-	formSrv, err := forms.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Form client: %v", err)
+// Matches the race name and date at the start of a race option on the form, such as
+// "Race 1: 2025-06-01 18:00 at Dock (2 Remaining)"
+var raceOptionPattern = regexp.MustCompile(`^(.+?): (\d{4}-\d{2}-\d{2})\b`)
+
+// Returns the race name and date of a race option on the form, so that races sharing a
+// name are told apart. The date is empty for options without one.
+func parseRaceOption(option string) (string, string) {
+	option = strings.TrimSpace(option)
+	if match := raceOptionPattern.FindStringSubmatch(option); match != nil {
+		return strings.TrimSpace(match[1]), match[2]
 	}
+	return strings.TrimSpace(strings.Split(option, ":")[0]), ""
+}
 
-	targetForm, err := formSrv.Forms.Get(formConfig.FormCode).Do()
+// Loads the race named in a command, using the date to choose between races that share the
+// name. The date is required when more than one race has the name.
+func findCommandRace(db *gorm.DB, raceName string, date string) *Race {
+	races := []*Race{}
+	if err := db.Where(&Race{Name: raceName, Date: date}).Order("date").Find(&races).Error; err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+
+	if len(races) == 0 && len(date) > 0 {
+		log.Fatalf("No race found named '%v' on %v", raceName, date)
+	} else if len(races) == 0 {
+		log.Fatalf("No race found named '%v'", raceName)
+	} else if len(races) > 1 {
+		dates := []string{}
+		for _, race := range races {
+			dates = append(dates, race.Date)
+		}
+		log.Fatalf("%v races are named '%v' - choose one with -date: %v", len(races), raceName, strings.Join(dates, ", "))
+	}
+	return races[0]
+}
+
+func updateGoogleForm(progConfig ProgramConfig, formConfig FormConfig, db *gorm.DB, services SyncServices, updatedRaces *map[string]*Race) {
+	formStore := services.Forms
+
+	targetForm, err := formStore.GetForm(formConfig.FormCode)
 	if err != nil {
 		log.Fatalf("Unable to retrieve Form client: %v", err)
 	}
@@ -161,83 +294,109 @@ func updateGoogleForm(progConfig ProgramConfig, formConfig FormConfig, db *gorm.
 	}
 
 	// Get form responses and link user values
-	responses, err := formSrv.Forms.Responses.List(formConfig.FormCode).Filter(fmt.Sprintf("timestamp > %s", progConfig.LastRun.Format(time.RFC3339))).Do()
+	responseItems, err := formStore.ListResponses(formConfig.FormCode)
 	if err != nil {
 		log.Fatalf("Unable to get forms responses: %v", err)
 	}
 
-	responseItems := responses.Responses
 	slices.SortFunc(responseItems, cmpResponse)
 
-	for _, response := range responseItems {
-		userEmail := response.RespondentEmail
-		userEmail = strings.ToLower(strings.TrimSpace(userEmail))
+	// Responses already handled by a previous run are skipped, and each response is
+	// applied in the same transaction that marks it as processed so that a failed
+	// run never applies a response twice
+	seedProcessedResponses(db, formConfig, responseItems, progConfig.LastRun)
 
-		targetUser := &User{
-			Email: userEmail,
+	for _, response := range responseItems {
+		if isResponseProcessed(db, response) {
+			continue
 		}
-		err := db.Where(targetUser).First(targetUser).Error
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				db.Create(targetUser)
-			} else {
-				log.Fatalf("Database error: %v", err)
+
+		// Emails are only sent once the response has been saved
+		messages := []EmailMessage{}
+		notify := func(user *User, race *Race, outcome string) {
+			if message, exists := newSignupMessage(progConfig, formConfig, user, race, outcome); exists {
+				messages = append(messages, message)
 			}
 		}
 
-		targetUser.Name = response.Answers[questionMap["name"]].TextAnswers.Answers[0].Value
-		action := strings.ToLower(response.Answers[questionMap["action"]].TextAnswers.Answers[0].Value)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			userEmail := response.RespondentEmail
+			userEmail = strings.ToLower(strings.TrimSpace(userEmail))
 
-		for _, raceQuestionText := range response.Answers[raceItem.Item.QuestionItem.Question.QuestionId].TextAnswers.Answers {
-			raceName := strings.TrimSpace(strings.Split(raceQuestionText.Value, ":")[0])
-
-			targetRace := &Race{
-				Name: raceName,
+			targetUser := &User{
+				Email: userEmail,
 			}
-
-			err := db.Preload(formConfig.TableName).Where(targetRace).First(targetRace).Error
+			err := tx.Where(targetUser).First(targetUser).Error
 			if err != nil {
-				if err == gorm.ErrRecordNotFound {
-					log.Printf("No record found for %v", raceName)
-					continue
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					tx.Create(targetUser)
 				} else {
 					log.Fatalf("Database error: %v", err)
 				}
 			}
 
-			listWithoutUser := []*User{}
-			userTable := formConfig.getUserTable(targetRace)
-			for _, user := range *userTable {
-				if user.ID != targetUser.ID {
-					listWithoutUser = append(listWithoutUser, user)
+			targetUser.Name = response.Answers[questionMap["name"]].TextAnswers.Answers[0].Value
+			action := strings.ToLower(response.Answers[questionMap["action"]].TextAnswers.Answers[0].Value)
+
+			for _, raceQuestionText := range response.Answers[raceItem.Item.QuestionItem.Question.QuestionId].TextAnswers.Answers {
+				raceName, raceDate := parseRaceOption(raceQuestionText.Value)
+
+				targetRace, err := formConfig.findRaceOn(tx, raceName, raceDate)
+				if err != nil {
+					if err == gorm.ErrRecordNotFound {
+						log.Printf("No record found for %v", raceName)
+						if err := recordSignupEvent(tx, formConfig, response.ResponseId, responseTimestamp(response), targetUser, nil, raceName, action, outcomeRejectedUnknownRace); err != nil {
+							return err
+						}
+						continue
+					} else {
+						log.Fatalf("Database error: %v", err)
+					}
 				}
-			}
 
-			if formConfig.canPerformActionForUser(targetUser) {
-				if action == "signup" && (formConfig.EntryLimit < 0 || len(listWithoutUser) < formConfig.EntryLimit) {
-					listWithoutUser = append(listWithoutUser, targetUser)
-				} else if action == "cancel" {
-					db.Model(targetRace).Association(formConfig.TableName).Clear()
-				} else {
-					log.Fatalf("Unknown action %v", action)
+				result, err := formConfig.processAction(tx, targetRace, targetUser, action)
+				if err != nil {
+					return err
+				}
+				if !isRejected(result.Outcome) {
+					if err := tx.Model(&Race{}).Where("id = ?", targetRace.ID).Update("CalendarOutdated", true).Error; err != nil {
+						return err
+					}
+				}
+				if err := recordSignupEvent(tx, formConfig, response.ResponseId, responseTimestamp(response), targetUser, targetRace, raceName, action, result.Outcome); err != nil {
+					return err
+				}
+				notify(targetUser, targetRace, result.Outcome)
+				for _, promotedUser := range result.Promoted {
+					if err := recordSignupEvent(tx, formConfig, response.ResponseId, responseTimestamp(response), promotedUser, targetRace, raceName, actionPromotion, outcomePromoted); err != nil {
+						return err
+					}
+					notify(promotedUser, targetRace, outcomePromoted)
 				}
-			}
 
-			if updatedRaces != nil {
-				if _, exists := (*updatedRaces)[raceName]; !exists {
-					(*updatedRaces)[raceName] = targetRace
+				if updatedRaces != nil {
+					if _, exists := (*updatedRaces)[raceName]; !exists {
+						(*updatedRaces)[raceName] = targetRace
+					}
 				}
-			}
 
-			log.Printf("%s %s for %s - %v\n", targetUser.Email, action, targetRace.Name, raceName)
+				log.Printf("%s %s for %s - %v (%v)\n", targetUser.Email, action, targetRace.Name, raceName, result.Outcome)
+				services.Plan.recordResponse(formConfig.Role, targetUser.Email, action, targetRace.Name)
+			}
 
-			*userTable = listWithoutUser
-			db.Save(targetRace)
+			tx.Save(&targetUser)
+			return markResponseProcessed(tx, formConfig, response)
+		})
+		if err != nil {
+			log.Fatalf("Unable to process response %v: %v", response.ResponseId, err)
 		}
 
-		db.Save(&targetUser)
+		sendMessages(services.Notifier, messages)
 	}
 
+	// Draw the lotteries that have closed, including the requests from the responses above
+	drawLotteries(progConfig, formConfig, db, services, updatedRaces, time.Now())
+
 	// Get all the races
 	allRaces := getAllRaces(db)
 
@@ -251,19 +410,37 @@ func updateGoogleForm(progConfig ProgramConfig, formConfig FormConfig, db *gorm.
 	currentTime := time.Now()
 
 	for _, race := range allRaces {
-		raceTime := race.Time(progConfig.timezone())
-		validRaceTime := raceTime.After(currentTime)
+		if formConfig.isRaceOpen(race, currentTime, progConfig.timezone()) {
+			entryName := fmt.Sprintf("%s: %s", race.Name, race.Date)
+			if len(race.StartTime) > 0 {
+				entryName = fmt.Sprintf("%s %s", entryName, race.StartTime)
+			}
+			if len(race.Location) > 0 {
+				entryName = fmt.Sprintf("%s at %s", entryName, race.Location)
+			}
 
-		if formConfig.ShowEntryTimeLimit != nil && validRaceTime {
-			validRaceTime = currentTime.After(raceTime.Add(-*formConfig.ShowEntryTimeLimit))
-		}
+			userList := formConfig.getUsers(race)
+			entryLimit := formConfig.entryLimit(race)
 
-		if validRaceTime {
-			entryName := fmt.Sprintf("%s: %s", race.Name, race.Date)
-			userList := *formConfig.getUserTable(race)
+			waitlist := formConfig.getWaitlist(race)
 
-			if formConfig.EntryLimit >= 0 {
-				entryName = fmt.Sprintf("%s (%v Remaining)", entryName, formConfig.EntryLimit-len(userList))
+			pending, err := formConfig.lotteryPending(db, race)
+			if err != nil {
+				log.Fatalf("Database error: %v", err)
+			}
+			requests, err := formConfig.lotteryRequests(db, race)
+			if err != nil {
+				log.Fatalf("Database error: %v", err)
+			}
+
+			if pending && entryLimit >= 0 {
+				entryName = fmt.Sprintf("%s (Lottery for %v Spaces, %v Requested)", entryName, max(entryLimit-len(userList), 0), len(requests))
+			} else if pending {
+				entryName = fmt.Sprintf("%s (Lottery, %v Requested)", entryName, len(requests))
+			} else if len(waitlist) > 0 {
+				entryName = fmt.Sprintf("%s (%v Remaining, %v Waitlisted)", entryName, max(entryLimit-len(userList), 0), len(waitlist))
+			} else if entryLimit >= 0 {
+				entryName = fmt.Sprintf("%s (%v Remaining)", entryName, entryLimit-len(userList))
 			} else {
 				entryName = fmt.Sprintf("%s (%v So Far)", entryName, len(userList))
 			}
@@ -280,118 +457,143 @@ func updateGoogleForm(progConfig ProgramConfig, formConfig FormConfig, db *gorm.
 
 	raceItem.Item.QuestionItem.Question.ChoiceQuestion.Options = newOptions
 
-	_, err = formSrv.Forms.BatchUpdate(formConfig.FormCode, &forms.BatchUpdateFormRequest{
-		IncludeFormInResponse: false,
-		Requests: []*forms.Request{
-			{
-				UpdateItem: &forms.UpdateItemRequest{
-					Item:       raceItem.Item,
-					UpdateMask: "questionItem",
-					Location:   &forms.Location{Index: raceItem.Index},
-				},
-			},
-		},
-	}).Do()
-
-	log.Printf("Updated Races on Form for %v: %v\n", formConfig.TableName, targetForm.Info.Title)
+	err = formStore.UpdateItem(formConfig.FormCode, raceItem.Item, raceItem.Index)
+
+	log.Printf("Updated Races on Form for %v: %v\n", formConfig.Label, targetForm.Info.Title)
 
 	if err != nil {
 		log.Fatalf("Unable to update form: %v", err)
 	}
 }
 
-func updateGoogleCalendar(progConfig ProgramConfig, db *gorm.DB, ctx context.Context, client *http.Client, updatedRaces map[string]*Race, forceCalendarUpdate bool) {
-	// Create the calendar service to update new calendar events
-	calSrv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
-	}
-
-	allRaces := getAllRaces(db)
+// Returns the calendar event description listing who is signed up for each role, the
+// remaining spaces, and the waitlists
+func (race Race) calendarDescription(progConfig ProgramConfig) string {
+	return race.describeSignups(progConfig, func(u *User) string { return u.Name })
+}
 
-	for _, race := range allRaces {
-		if _, raceExists := updatedRaces[race.Name]; (!raceExists && race.EventID != nil) && !forceCalendarUpdate {
-			continue
+// Returns the calendar event description, showing each user with the given display name
+func (race Race) describeSignups(progConfig ProgramConfig, displayName func(*User) string) string {
+	descriptionLines := []string{}
+	for _, role := range progConfig.roles() {
+		names := []string{}
+		for _, signup := range race.signupsFor(role.Name) {
+			if !role.Boats {
+				names = append(names, displayName(signup.User))
+			} else if signup.Boat != nil {
+				names = append(names, fmt.Sprintf("%v - %v", displayName(signup.User), signup.Boat.label()))
+			} else {
+				names = append(names, fmt.Sprintf("%v - no boat", displayName(signup.User)))
+			}
 		}
 
-		eventTime := race.Time(progConfig.timezone())
-		eventTime = eventTime.Add(time.Duration(progConfig.eventStartOffset()))
+		if len(names) > 0 {
+			descriptionLines = append(descriptionLines, fmt.Sprintf("%v: %v", role.label(), strings.Join(names, ", ")))
+		} else {
+			descriptionLines = append(descriptionLines, fmt.Sprintf("No %v", role.label()))
+		}
+	}
 
-		cdrStart := calendar.EventDateTime{DateTime: eventTime.Format(time.RFC3339)}
-		cdrEnd := calendar.EventDateTime{DateTime: eventTime.Add(progConfig.eventDuration()).Format(time.RFC3339)}
+	for _, role := range progConfig.roles() {
+		if limit := race.entryLimit(role.Name, role.EntryLimit); limit >= 0 {
+			descriptionLines = append(descriptionLines, fmt.Sprintf("%v Remaining: %v", role.label(), limit-len(race.usersFor(role.Name))))
+		}
+	}
 
-		descriptionTextRC := "No RC"
+	descriptionText := strings.Join(descriptionLines, "\n")
 
-		attendees := map[string]*calendar.EventAttendee{}
-		for i, rcUser := range race.RC {
-			newUser := calendar.EventAttendee{
-				Email:       rcUser.Email,
-				DisplayName: rcUser.Name,
-			}
+	if len(race.Description) > 0 {
+		descriptionText = fmt.Sprintf("%v\n\n%v", race.Description, descriptionText)
+	}
 
-			attendees[newUser.Email] = &newUser
+	if race.Cancelled {
+		descriptionText = fmt.Sprintf("%v\n\n%v", race.cancellationNotice(), descriptionText)
+	}
 
-			if i == 0 {
-				descriptionTextRC = fmt.Sprintf("RC: %v", rcUser.Name)
-			} else {
-				descriptionTextRC = fmt.Sprintf("%v, %v", descriptionTextRC, rcUser.Name)
-			}
+	for _, role := range progConfig.roles() {
+		if names := race.waitlistNames(role.Name, displayName); len(names) > 0 {
+			descriptionText = fmt.Sprintf("%v\n%v Waitlist: %v", descriptionText, role.label(), names)
 		}
+	}
 
-		descriptionTextRental := "No Renters"
-		for i, rentalUser := range race.Renters {
-			newUser := calendar.EventAttendee{
-				Email:       rentalUser.Email,
-				DisplayName: rentalUser.Name,
-			}
+	return descriptionText
+}
 
-			attendees[newUser.Email] = &newUser
+// Returns an attendee for each user signed up for the race in any role
+func (race Race) calendarAttendees() []*calendar.EventAttendee {
+	attendees := map[string]*calendar.EventAttendee{}
+	for _, signup := range race.Signups {
+		attendees[signup.User.Email] = &calendar.EventAttendee{
+			Email:       signup.User.Email,
+			DisplayName: signup.User.Name,
+		}
+	}
+	return maps.Values(attendees)
+}
 
-			if i == 0 {
-				descriptionTextRental = fmt.Sprintf("Renters: %v", rentalUser.Name)
-			} else {
-				descriptionTextRental = fmt.Sprintf("%v, %v", descriptionTextRental, rentalUser.Name)
-			}
+func updateGoogleCalendar(progConfig ProgramConfig, db *gorm.DB, calStore CalendarStore, notifier Notifier, updatedRaces map[string]*Race, forceCalendarUpdate bool) {
+	allRaces := getAllRaces(db)
+
+	for _, race := range allRaces {
+		if _, raceExists := updatedRaces[race.Name]; (!raceExists && race.EventID != nil && !race.CalendarOutdated) && !forceCalendarUpdate {
+			continue
 		}
 
-		descriptionText := fmt.Sprintf("%v\n%v\nRentals Remaining: %v", descriptionTextRC, descriptionTextRental, progConfig.AllowedRentersCount-len(race.Renters))
+		eventTime := race.startTime(progConfig)
+
+		cdrStart := calendar.EventDateTime{DateTime: eventTime.Format(time.RFC3339)}
+		cdrEnd := calendar.EventDateTime{DateTime: eventTime.Add(race.duration(progConfig)).Format(time.RFC3339)}
+
+		descriptionText := race.calendarDescription(progConfig)
+		attendees := race.calendarAttendees()
+
+		// Attendees are only notified once, when the cancellation is first added to the event
+		notify := race.Cancelled && !race.CancelNotified
+		notified := false
 
 		if race.EventID != nil {
-			existingEvent, err := calSrv.Events.Get(progConfig.CalendarCode, *race.EventID).Do()
+			existingEvent, err := calStore.GetEvent(*race.EventID)
 			if err != nil {
 				log.Fatalf("Unable to get existing event: %v", err)
 			}
 
 			existingEvent.Start = &cdrStart
 			existingEvent.End = &cdrEnd
-			existingEvent.Summary = race.Name
+			existingEvent.Summary = race.calendarSummary()
 			existingEvent.Description = descriptionText
-			existingEvent.Attendees = maps.Values(attendees)
+			existingEvent.Attendees = attendees
 
-			if len(progConfig.RaceLocation) > 0 {
-				existingEvent.Location = progConfig.RaceLocation
+			if location := race.location(progConfig); len(location) > 0 {
+				existingEvent.Location = location
 			}
 
-			_, err = calSrv.Events.Update(progConfig.CalendarCode, *race.EventID, existingEvent).Do()
+			notified = notify && calStore.NotifiesAttendees()
+			updatedEvent, err := calStore.UpdateEvent(*race.EventID, existingEvent, notified)
 			if err != nil {
 				log.Fatalf("Error updating event %v: %v", existingEvent.Id, err)
+			} else if notified {
+				log.Printf("Updated event %v and notified attendees of the cancellation\n", race.Name)
 			} else {
 				log.Printf("Updated event %v\n", race.Name)
 			}
+
+			if updatedEvent.Etag != race.EventETag {
+				db.Model(race).Update("EventETag", updatedEvent.Etag)
+			}
 		} else {
 			newEvent := calendar.Event{
 				Start:       &cdrStart,
 				End:         &cdrEnd,
-				Summary:     race.Name,
-				Attendees:   maps.Values(attendees),
-				Description: descriptionTextRC,
+				Summary:     race.calendarSummary(),
+				Attendees:   attendees,
+				Description: descriptionText,
 			}
 
-			if len(progConfig.RaceLocation) > 0 {
-				newEvent.Location = progConfig.RaceLocation
+			if location := race.location(progConfig); len(location) > 0 {
+				newEvent.Location = location
 			}
 
-			eventResult, err := calSrv.Events.Insert(progConfig.CalendarCode, &newEvent).Do()
+			eventResult, err := calStore.InsertEvent(&newEvent)
 			if err != nil {
 				log.Fatalf("Unable to add calendar event: %v", err)
 			}
@@ -399,7 +601,26 @@ func updateGoogleCalendar(progConfig ProgramConfig, db *gorm.DB, ctx context.Con
 			log.Printf("Added calendar event for %v with id %v\n", race.Name, eventResult.Id)
 
 			race.EventID = &eventResult.Id
+			race.EventETag = eventResult.Etag
 			db.Save(&race)
 		}
+
+		// Calendars that cannot notify attendees, and new events, fall back to email
+		if notify && !notified {
+			if notifier == nil {
+				log.Printf("No mail server configured, so the attendees of %v were not notified of the cancellation\n", race.Name)
+			} else {
+				sendMessages(notifier, cancellationMessages(progConfig, race))
+				log.Printf("Emailed the attendees of %v about the cancellation\n", race.Name)
+				notified = true
+			}
+		}
+
+		if race.CalendarOutdated {
+			db.Model(race).Update("CalendarOutdated", false)
+		}
+		if notified {
+			db.Model(race).Update("CancelNotified", true)
+		}
 	}
 }
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+)
+
+// Returns the membership source for the configured membership source type, which is
+// the AllowedUsersSheetID Google Sheet by default
+func (config ProgramConfig) newMembershipSource(ctx context.Context, client *http.Client) MembershipSource {
+	if source := config.newFileMembershipSource(); source != nil {
+		return source
+	}
+	return newGoogleSheetSource(ctx, client, config.AllowedUsersSheetID)
+}
+
+// Returns the membership source for commands that only need the membership list, which
+// only signs in to Google if the membership list is a Google Sheet
+func (config ProgramConfig) openMembershipSource() MembershipSource {
+	if source := config.newFileMembershipSource(); source != nil {
+		return source
+	}
+
+	ctx, client := getGoogleContext(config)
+	return newGoogleSheetSource(ctx, client, config.AllowedUsersSheetID)
+}
+
+// Returns the source for a local membership file, or nil if the membership list is a Google Sheet
+func (config ProgramConfig) newFileMembershipSource() MembershipSource {
+	switch config.MembershipSourceType {
+	case "", "sheet":
+		return nil
+	case "csv":
+		return &csvMembershipSource{file: config.membershipFile()}
+	case "xlsx":
+		return &xlsxMembershipSource{file: config.membershipFile()}
+	default:
+		log.Fatalf("Unknown membership source type '%v'", config.MembershipSourceType)
+		return nil
+	}
+}
+
+// Returns the membership file, relative to the data folder unless the path is absolute
+func (config ProgramConfig) membershipFile() string {
+	if len(config.MembershipFile) == 0 {
+		log.Fatalf("MembershipFile must be set for membership source type '%v'", config.MembershipSourceType)
+	}
+	if path.IsAbs(config.MembershipFile) {
+		return config.MembershipFile
+	}
+	return path.Join(config.DataFolder, config.MembershipFile)
+}
+
+// MembershipColumns names the headers of the columns of the membership list. If no headers
+// are set, the email, name, and membership year are read from columns A:C, and the membership
+// type and expiry date are only read when their headers are set.
+type MembershipColumns struct {
+	Email   string
+	Name    string
+	Year    string
+	Type    string
+	Expires string
+}
+
+// membershipLayout is the position of each column in the membership rows, with -1 for
+// optional columns that are not in the list
+type membershipLayout struct {
+	email   int
+	name    int
+	year    int
+	kind    int
+	expires int
+	start   int
+}
+
+// Finds the columns in the membership rows, using the first row containing the email
+// header as the header row if headers are configured
+func (columns MembershipColumns) layout(rows [][]string) (membershipLayout, error) {
+	if columns == (MembershipColumns{}) {
+		layout := membershipLayout{email: 0, name: 1, year: 2, kind: -1, expires: -1, start: 0}
+
+		// Skip the header row of a list without configured headers
+		if len(rows) > 0 && !strings.Contains(membershipCell(rows[0], 0), "@") {
+			layout.start = 1
+		}
+		return layout, nil
+	} else if len(columns.Email) == 0 || len(columns.Name) == 0 {
+		return membershipLayout{}, fmt.Errorf("the Email and Name membership columns must both be set")
+	}
+
+	for i, row := range rows {
+		find := func(header string) int {
+			for j, cell := range row {
+				if len(header) > 0 && strings.EqualFold(strings.TrimSpace(cell), header) {
+					return j
+				}
+			}
+			return -1
+		}
+
+		layout := membershipLayout{
+			email:   find(columns.Email),
+			name:    find(columns.Name),
+			year:    find(columns.Year),
+			kind:    find(columns.Type),
+			expires: find(columns.Expires),
+			start:   i + 1,
+		}
+		if layout.email < 0 {
+			continue
+		}
+
+		for header, column := range map[string]int{columns.Name: layout.name, columns.Year: layout.year, columns.Type: layout.kind, columns.Expires: layout.expires} {
+			if len(header) > 0 && column < 0 {
+				return membershipLayout{}, fmt.Errorf("column '%v' not found in membership header row %v", header, i+1)
+			}
+		}
+		return layout, nil
+	}
+
+	return membershipLayout{}, fmt.Errorf("email column '%v' not found in the membership list", columns.Email)
+}
+
+// Returns the trimmed cell, or an empty string if the row is too short
+func membershipCell(row []string, column int) string {
+	if column < 0 || column >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[column])
+}
+
+// Parses a membership year, allowing the decimal values that spreadsheets may export
+func parseMembershipYear(value string) (int, error) {
+	if year, err := strconv.Atoi(value); err == nil {
+		return year, nil
+	}
+
+	year, err := strconv.ParseFloat(value, 64)
+	if err != nil || year != math.Trunc(year) {
+		return 0, fmt.Errorf("invalid membership year '%v'", value)
+	}
+	return int(year), nil
+}
+
+var membershipDateFormats = []string{time.DateOnly, "1/2/2006", "2006/01/02", "Jan 2, 2006", "January 2, 2006", "2 Jan 2006"}
+
+// Parses a membership expiry date, which may also be the day number that XLSX files store dates as
+func parseMembershipExpiry(value string, loc *time.Location) (time.Time, error) {
+	for _, format := range membershipDateFormats {
+		if t, err := time.ParseInLocation(format, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	if days, err := strconv.ParseFloat(value, 64); err == nil && days > 0 {
+		return time.Date(1899, 12, 30, 0, 0, 0, 0, loc).AddDate(0, 0, int(days)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry date '%v'", value)
+}
+
+// Returns the members whose membership year is at least the RentalMembershipYear and whose
+// membership has not expired, logging and skipping rows that cannot be read
+func (config ProgramConfig) getValidSheetEmails(source MembershipSource) ([]UserEntry, error) {
+	rows, err := source.MembershipRows()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve membership list: %v", err)
+	}
+
+	layout, err := config.MembershipColumns.layout(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := config.timezone()
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	users := map[string]UserEntry{}
+	skipped := map[string]int{}
+	skip := func(i int, reason string, detail string) {
+		skipped[reason] += 1
+		log.Printf("Skipping membership row %v (%v): %v", i+1, reason, detail)
+	}
+
+	for i := layout.start; i < len(rows); i++ {
+		row := rows[i]
+		emails := strings.ToLower(membershipCell(row, layout.email))
+		name := membershipCell(row, layout.name)
+		yearValue := membershipCell(row, layout.year)
+		membershipType := strings.ToLower(membershipCell(row, layout.kind))
+		expiresValue := membershipCell(row, layout.expires)
+
+		if len(strings.TrimSpace(strings.Join(row, ""))) == 0 {
+			// Blank rows are only counted, as lists often end with them
+			skipped["blank"] += 1
+			continue
+		} else if len(row) <= max(layout.email, layout.name) {
+			skip(i, "short", fmt.Sprintf("only %v columns in %v", len(row), row))
+			continue
+		} else if len(emails) == 0 || len(name) == 0 {
+			skip(i, "missing email or name", fmt.Sprintf("email '%v', name '%v'", emails, name))
+			continue
+		}
+
+		if layout.year >= 0 && config.RentalMembershipYear > 0 {
+			if len(yearValue) == 0 {
+				skip(i, "missing year", fmt.Sprintf("no membership year for %v", name))
+				continue
+			}
+
+			membershipYear, err := parseMembershipYear(yearValue)
+			if err != nil {
+				skip(i, "invalid year", fmt.Sprintf("%v for %v", err, name))
+				continue
+			} else if membershipYear < config.RentalMembershipYear {
+				skip(i, "expired", fmt.Sprintf("membership year %v < %v for %v", membershipYear, config.RentalMembershipYear, name))
+				continue
+			}
+		}
+
+		// Memberships without an expiry date do not expire
+		expires := time.Time{}
+		if len(expiresValue) > 0 {
+			expires, err = parseMembershipExpiry(expiresValue, loc)
+			if err != nil {
+				skip(i, "invalid expiry", fmt.Sprintf("%v for %v", err, name))
+				continue
+			} else if expires.Before(today) {
+				skip(i, "expired", fmt.Sprintf("membership expired on %v for %v", expires.Format(time.DateOnly), name))
+				continue
+			}
+		}
+
+		for _, email := range strings.Split(emails, ";") {
+			email = strings.TrimSpace(email)
+			if len(email) == 0 {
+				continue
+			} else if !strings.Contains(email, "@") {
+				log.Printf("Invalid email '%v' for '%v' in membership row %v", email, name, i+1)
+			} else if _, exists := users[email]; !exists {
+				users[email] = UserEntry{Email: email, Name: name, Type: membershipType, Expires: expires}
+			} else {
+				log.Printf("Duplicate entry for email '%v' detected as '%v'", email, name)
+			}
+		}
+	}
+
+	if len(skipped) > 0 {
+		reasons := maps.Keys(skipped)
+		sort.Strings(reasons)
+
+		counts := []string{}
+		total := 0
+		for _, reason := range reasons {
+			counts = append(counts, fmt.Sprintf("%v %v", skipped[reason], reason))
+			total += skipped[reason]
+		}
+		log.Printf("Membership list: %v members, skipped %v rows (%v)", len(users), total, strings.Join(counts, ", "))
+	}
+
+	return maps.Values(users), nil
+}
+
+// csvMembershipSource reads the membership list from a CSV export
+type csvMembershipSource struct {
+	file string
+}
+
+func (source *csvMembershipSource) MembershipRows() ([][]string, error) {
+	f, err := os.Open(source.file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %v: %v", source.file, err)
+	}
+
+	// Spreadsheet programs often start CSV exports with a byte order mark
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+
+	return rows, nil
+}
+
+// xlsxMembershipSource reads the membership list from the first worksheet of an Excel workbook
+type xlsxMembershipSource struct {
+	file string
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is a shared or inline string, which is either plain text or a list of rich text runs
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func (text xlsxText) String() string {
+	if len(text.Runs) == 0 {
+		return text.Text
+	}
+
+	var value strings.Builder
+	for _, run := range text.Runs {
+		value.WriteString(run.Text)
+	}
+	return value.String()
+}
+
+func (source *xlsxMembershipSource) MembershipRows() ([][]string, error) {
+	archive, err := zip.OpenReader(source.file)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	workbook := xlsxWorkbook{}
+	if err := readXLSXPart(&archive.Reader, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	} else if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("no worksheets found in %v", source.file)
+	}
+
+	rels := xlsxRelationships{}
+	if err := readXLSXPart(&archive.Reader, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+
+	sheetPart := ""
+	for _, rel := range rels.Relationships {
+		if rel.ID == workbook.Sheets[0].RID {
+			if strings.HasPrefix(rel.Target, "/") {
+				sheetPart = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				sheetPart = path.Join("xl", rel.Target)
+			}
+		}
+	}
+	if len(sheetPart) == 0 {
+		return nil, fmt.Errorf("worksheet %v not found in %v", workbook.Sheets[0].Name, source.file)
+	}
+
+	// Workbooks without any text cells do not include the shared strings
+	sharedStrings := xlsxSharedStrings{}
+	if err := readXLSXPart(&archive.Reader, "xl/sharedStrings.xml", &sharedStrings); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sheet := xlsxWorksheet{}
+	if err := readXLSXPart(&archive.Reader, sheetPart, &sheet); err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{}
+	for _, row := range sheet.Rows {
+		cells := []string{}
+		for _, cell := range row.Cells {
+			// Empty cells are left out of the row, so place each cell by its reference
+			if column := xlsxColumn(cell.Ref); column >= 0 {
+				for len(cells) < column {
+					cells = append(cells, "")
+				}
+			}
+
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("invalid shared string '%v' in cell %v", cell.Value, cell.Ref)
+				}
+				value = sharedStrings.Items[index].String()
+			case "inlineStr":
+				value = cell.Inline.String()
+			}
+			cells = append(cells, value)
+		}
+		rows = append(rows, cells)
+	}
+
+	return rows, nil
+}
+
+// Decodes an XML part of the workbook, returning an error satisfying os.IsNotExist if the part is missing
+func readXLSXPart(archive *zip.Reader, name string, v interface{}) error {
+	f, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to parse %v: %v", name, err)
+	}
+	return nil
+}
+
+// Returns the zero-based column index of a cell reference such as "C12", or -1 if there is no column
+func xlsxColumn(ref string) int {
+	column := 0
+	for _, c := range strings.ToUpper(ref) {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		column = column*26 + int(c-'A') + 1
+	}
+	return column - 1
+}
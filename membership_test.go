@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPositionalMembershipReadsOnlyFirstThreeColumns(t *testing.T) {
+	progConfig := ProgramConfig{RentalMembershipYear: 2020}
+	source := &memoryMembershipSource{Rows: [][]string{
+		{"Email", "Name", "Year", "Notes", "Phone"},
+		{"member@example.org", "Member", "2020", "paid late", "555-0100"},
+	}}
+
+	users, err := progConfig.getValidSheetEmails(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("expected one member, got %v", users)
+	}
+	if users[0].Type != "" || !users[0].Expires.IsZero() {
+		t.Errorf("read type '%v' and expiry %v from unconfigured columns", users[0].Type, users[0].Expires)
+	}
+}
+
+func TestMembershipLayoutFindsHeaderRow(t *testing.T) {
+	columns := MembershipColumns{Email: "E-mail", Name: "Member Name", Year: "Year", Expires: "Expires"}
+	rows := [][]string{
+		{"Club Membership Export"},
+		{},
+		{"Member Name", " e-mail ", "Phone", "Expires", "Year"},
+		{"Member", "member@example.org", "555-0100", "2030-01-01", "2025"},
+	}
+
+	layout, err := columns.layout(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := membershipLayout{email: 1, name: 0, year: 4, kind: -1, expires: 3, start: 3}
+	if layout != expected {
+		t.Errorf("expected layout %+v, got %+v", expected, layout)
+	}
+}
+
+func TestMembershipLayoutWithoutHeaders(t *testing.T) {
+	layout, _ := MembershipColumns{}.layout([][]string{{"Email", "Name", "Year"}, {"member@example.org", "Member", "2025"}})
+	if layout.start != 1 {
+		t.Errorf("expected the header row to be skipped, starting at %v", layout.start)
+	}
+
+	layout, _ = MembershipColumns{}.layout([][]string{{"member@example.org", "Member", "2025"}})
+	if layout.start != 0 {
+		t.Errorf("expected a list without a header row to start at the first row, got %v", layout.start)
+	}
+}
+
+func TestMembershipLayoutMissingHeader(t *testing.T) {
+	rows := [][]string{{"Email", "Name", "Year"}, {"member@example.org", "Member", "2025"}}
+
+	_, err := MembershipColumns{Email: "Email", Name: "Name", Expires: "Expires"}.layout(rows)
+	if err == nil || !strings.Contains(err.Error(), "'Expires'") {
+		t.Errorf("expected an error for the missing Expires header, got %v", err)
+	}
+
+	_, err = MembershipColumns{Email: "Address", Name: "Name"}.layout(rows)
+	if err == nil || !strings.Contains(err.Error(), "'Address'") {
+		t.Errorf("expected an error for the missing email header, got %v", err)
+	}
+
+	_, err = MembershipColumns{Email: "Email"}.layout(rows)
+	if err == nil {
+		t.Errorf("expected an error when the Name header is not set")
+	}
+}
+
+func TestMembershipSkipsUnreadableRows(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	progConfig := ProgramConfig{RentalMembershipYear: 2025, MembershipColumns: MembershipColumns{Email: "Email", Name: "Name", Year: "Year"}}
+	source := &memoryMembershipSource{Rows: [][]string{
+		{"Email", "Name", "Year"},
+		{"a@example.org", "A Sailor", "2025"},
+		{"short@example.org"},
+		{"", " ", ""},
+		{"year@example.org", "Bad Year", "next"},
+		{"old@example.org", "Old Member", "2024"},
+		{"b@example.org", "B Sailor", "2026.0"},
+		{},
+	}}
+
+	users, err := progConfig.getValidSheetEmails(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emails := []string{}
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+	sort.Strings(emails)
+	if strings.Join(emails, ",") != "a@example.org,b@example.org" {
+		t.Errorf("expected only the valid rows to be kept, got %v", emails)
+	}
+
+	for _, line := range []string{
+		"Skipping membership row 3 (short)",
+		"Skipping membership row 5 (invalid year)",
+		"Skipping membership row 6 (expired)",
+		"Membership list: 2 members, skipped 5 rows (2 blank, 1 expired, 1 invalid year, 1 short)",
+	} {
+		if !strings.Contains(output.String(), line) {
+			t.Errorf("expected %q in the log:\n%v", line, output.String())
+		}
+	}
+	if strings.Contains(output.String(), "row 4") {
+		t.Errorf("expected blank rows to only be counted:\n%v", output.String())
+	}
+}
+
+// Writes a workbook with the given parts to a file, returning the file name
+func writeTestWorkbook(t *testing.T, parts map[string]string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "members.xlsx")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	archive := zip.NewWriter(f)
+	for name, contents := range parts {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, contents)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestXLSXMembershipRows(t *testing.T) {
+	file := writeTestWorkbook(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Members" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId2" Target="sharedStrings.xml"/><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Email</t></si><si><r><t>Na</t></r><r><rPr><b/></rPr><t>me</t></r></si><si><t>Year</t></si><si><t>Expires</t></si>
+<si><t>A Sailor</t></si><si><t>b@example.org</t></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c><c r="D1" t="s"><v>3</v></c></row>
+<row r="2"><c r="A2" t="inlineStr"><is><t>a@example.org</t></is></c><c r="B2" t="s"><v>4</v></c><c r="C2"><v>2025</v></c><c r="D2"><v>47000</v></c></row>
+<row r="3"><c r="A3" t="s"><v>5</v></c><c r="D3"><v>47000</v></c></row>
+</sheetData></worksheet>`,
+	})
+
+	rows, err := (&xlsxMembershipSource{file: file}).MembershipRows()
+	if err != nil {
+		t.Fatalf("reading workbook: %v", err)
+	}
+	expected := [][]string{
+		{"Email", "Name", "Year", "Expires"},
+		{"a@example.org", "A Sailor", "2025", "47000"},
+		{"b@example.org", "", "", "47000"},
+	}
+	if fmt.Sprintf("%q", rows) != fmt.Sprintf("%q", expected) {
+		t.Fatalf("expected rows %q, got %q", expected, rows)
+	}
+
+	expires, err := parseMembershipExpiry(rows[1][3], time.UTC)
+	if err != nil || expires.Format(time.DateOnly) != "2028-09-04" {
+		t.Errorf("expected the date serial to be 2028-09-04, got %v (%v)", expires, err)
+	}
+}
+
+func TestXLSXMembershipRowsWithoutSharedStrings(t *testing.T) {
+	file := writeTestWorkbook(t, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Members" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="/xl/worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData><row><c r="B1"><v>2025</v></c></row></sheetData></worksheet>`,
+	})
+
+	rows, err := (&xlsxMembershipSource{file: file}).MembershipRows()
+	if err != nil {
+		t.Fatalf("reading workbook: %v", err)
+	}
+	if fmt.Sprintf("%q", rows) != `[["" "2025"]]` {
+		t.Errorf("unexpected rows %q", rows)
+	}
+}
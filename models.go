@@ -2,9 +2,12 @@ package main
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -12,19 +15,56 @@ import (
 
 type User struct {
 	gorm.Model
-	Name        string
-	Email       string
-	RcRaces     []*Race `gorm:"many2many:user_rc_races;"`
-	RentalRaces []*Race `gorm:"many2many:user_rental_races;"`
+	Name      string
+	Email     string
+	FeedToken string `gorm:"index"`
+	Signups   []*RaceSignup
 }
 
 type Race struct {
 	gorm.Model
-	Name    string
-	Date    string
-	EventID *string
-	RC      []*User `gorm:"many2many:user_rc_races;"`
-	Renters []*User `gorm:"many2many:user_rental_races;"`
+	Name             string
+	Date             string
+	StartTime        string
+	DurationMinutes  int
+	Location         string
+	Description      string
+	EventID          *string
+	EventETag        string
+	CalendarOutdated bool
+	Cancelled        bool
+	CancelReason     string
+	CancelNotified   bool
+	Signups          []*RaceSignup
+	Waitlist         []*WaitlistEntry
+	Capacities       []*RaceCapacity
+	Minimums         []*RaceMinimum
+}
+
+// RaceCapacity overrides the entry limit of a role for a single race
+type RaceCapacity struct {
+	gorm.Model
+	RaceID uint
+	Role   string
+	Limit  int
+	Source string
+}
+
+// RaceMinimum overrides the minimum number of signups of a role for a single race
+type RaceMinimum struct {
+	gorm.Model
+	RaceID  uint
+	Role    string
+	Minimum int
+}
+
+// WaitlistEntry queues a user for a race role that has reached its entry limit
+type WaitlistEntry struct {
+	gorm.Model
+	RaceID uint
+	UserID uint
+	User   *User
+	Role   string
 }
 
 func (race Race) Time(loc *time.Location) time.Time {
@@ -39,34 +79,235 @@ func (race *Race) SetTime(t time.Time) {
 	race.Date = t.Format(time.DateOnly)
 }
 
-// Reads the race events input file
-func readRaceEvents(file string) []*Race {
+// Returns the start of the race, using the race start time if provided or the
+// configured start offset otherwise
+func (race Race) startTime(config ProgramConfig) time.Time {
+	day := race.Time(config.timezone())
+
+	offset := config.eventStartOffset()
+	hour, minute := int(offset.Hours()), int(offset.Minutes())%60
+	if len(race.StartTime) > 0 {
+		start, err := time.Parse(raceStartTimeFormat, race.StartTime)
+		if err != nil {
+			log.Fatalf("Unable to parse start time '%v' for %v: %v", race.StartTime, race.Name, err)
+		}
+		hour, minute = start.Hour(), start.Minute()
+	}
+
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+func (race Race) duration(config ProgramConfig) time.Duration {
+	if race.DurationMinutes > 0 {
+		return time.Duration(race.DurationMinutes) * time.Minute
+	}
+	return config.eventDuration()
+}
+
+// Returns the calendar event title, marking cancelled races
+func (race Race) calendarSummary() string {
+	if race.Cancelled {
+		return fmt.Sprintf("CANCELLED: %v", race.Name)
+	}
+	return race.Name
+}
+
+func (race Race) cancellationNotice() string {
+	if len(race.CancelReason) > 0 {
+		return fmt.Sprintf("This race has been cancelled: %v", race.CancelReason)
+	}
+	return "This race has been cancelled."
+}
+
+func (race Race) location(config ProgramConfig) string {
+	if len(race.Location) > 0 {
+		return race.Location
+	}
+	return config.RaceLocation
+}
+
+// Returns the capacity override for the given role, or nil if there is none
+func (race Race) capacityFor(role string) *RaceCapacity {
+	for _, capacity := range race.Capacities {
+		if capacity.Role == role {
+			return capacity
+		}
+	}
+	return nil
+}
+
+// Returns the entry limit for the given role, using the race capacity if one is set
+func (race Race) entryLimit(role string, defaultLimit int) int {
+	if capacity := race.capacityFor(role); capacity != nil {
+		return capacity.Limit
+	}
+	return defaultLimit
+}
+
+// Returns the minimum number of signups for the given role, using the race minimum if one is set
+func (race Race) minimumFor(role string, defaultMinimum int) int {
+	for _, minimum := range race.Minimums {
+		if minimum.Role == role {
+			return minimum.Minimum
+		}
+	}
+	return defaultMinimum
+}
+
+// Returns the waitlist entries for the given role in queue order
+func (race Race) waitlistFor(role string) []*WaitlistEntry {
+	entries := []*WaitlistEntry{}
+	for _, entry := range race.Waitlist {
+		if entry.Role == role {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Returns the waitlisted names for the given role along with their positions
+func (race Race) waitlistNames(role string, displayName func(*User) string) string {
+	names := []string{}
+	for i, entry := range race.waitlistFor(role) {
+		names = append(names, fmt.Sprintf("%v (%v)", displayName(entry.User), i+1))
+	}
+	return strings.Join(names, ", ")
+}
+
+const raceStartTimeFormat = "15:04"
+
+// Reads the race events input file. Columns are matched by header name, with the
+// name and date taken from the first two columns if no matching headers exist.
+// Optional columns are "start" (HH:MM), "duration" (hours or a duration such as
+// 2h30m), "location", "description", and "<role> capacity" and "<role> minimum" for
+// each signup role. Rows with an invalid date, start, duration, capacity, or minimum
+// are skipped with a warning, and the number of skipped rows is returned.
+func readRaceEvents(file string) ([]*Race, int, error) {
 	// open file
 	f, err := os.Open(file)
 	if err != nil {
-		return []*Race{}
+		return nil, 0, err
 	}
 	defer f.Close()
 
 	// Define the race list and loop through entries
 	var races = []*Race{}
+	var columns = map[string]int{}
 	var is_first = true
+	var skipped = 0
+	var row = 0
 
 	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
 	for {
 		rec, err := reader.Read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			return nil, 0, err
 		}
+		row += 1
 
 		if is_first {
 			is_first = false
-		} else {
-			races = append(races, &Race{Name: rec[0], Date: rec[1]})
+			for i, header := range rec {
+				columns[strings.ToLower(strings.TrimSpace(header))] = i
+			}
+			if _, exists := columns["name"]; !exists {
+				columns["name"] = 0
+			}
+			if _, exists := columns["date"]; !exists {
+				columns["date"] = 1
+			}
+			continue
 		}
+
+		if len(strings.TrimSpace(strings.Join(rec, ""))) == 0 {
+			continue
+		}
+
+		race, err := parseRaceRecord(rec, columns)
+		if err != nil {
+			skipped += 1
+			log.Printf("Skipping race row %v: %v", row, err)
+			continue
+		}
+		races = append(races, race)
 	}
 
-	return races
+	return races, skipped, nil
+}
+
+func parseRaceRecord(rec []string, columns map[string]int) (*Race, error) {
+	value := func(column string) string {
+		if i, exists := columns[column]; exists && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	race := &Race{
+		Name:        value("name"),
+		Date:        value("date"),
+		StartTime:   value("start"),
+		Location:    value("location"),
+		Description: value("description"),
+		Capacities:  []*RaceCapacity{},
+		Minimums:    []*RaceMinimum{},
+	}
+
+	if len(race.Name) == 0 {
+		return nil, fmt.Errorf("missing name")
+	} else if _, err := time.Parse(time.DateOnly, race.Date); err != nil {
+		return nil, fmt.Errorf("invalid date '%v' for %v - expected YYYY-MM-DD", race.Date, race.Name)
+	}
+
+	if len(race.StartTime) > 0 {
+		if _, err := time.Parse(raceStartTimeFormat, race.StartTime); err != nil {
+			return nil, fmt.Errorf("invalid start time '%v' for %v - expected HH:MM", race.StartTime, race.Name)
+		}
+	}
+
+	if duration := value("duration"); len(duration) > 0 {
+		minutes, err := parseRaceDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration '%v' for %v", duration, race.Name)
+		}
+		race.DurationMinutes = minutes
+	}
+
+	for column := range columns {
+		if role, found := strings.CutSuffix(column, " capacity"); found {
+			if limit := value(column); len(limit) > 0 {
+				count, err := strconv.Atoi(limit)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %v '%v' for %v", column, limit, race.Name)
+				}
+				race.Capacities = append(race.Capacities, &RaceCapacity{Role: role, Limit: count, Source: capacitySourceSchedule})
+			}
+		} else if role, found := strings.CutSuffix(column, " minimum"); found {
+			if minimum := value(column); len(minimum) > 0 {
+				count, err := strconv.Atoi(minimum)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %v '%v' for %v", column, minimum, race.Name)
+				}
+				race.Minimums = append(race.Minimums, &RaceMinimum{Role: role, Minimum: count})
+			}
+		}
+	}
+
+	return race, nil
+}
+
+// Returns the duration in minutes from either a number of hours or a duration string
+func parseRaceDuration(duration string) (int, error) {
+	if hours, err := strconv.ParseFloat(duration, 64); err == nil {
+		return int(hours * 60), nil
+	}
+
+	dur, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0, err
+	}
+	return int(dur.Minutes()), nil
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRaceEventsSkipsBadRows(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "races.csv")
+	contents := "Name,Date,Start,Duration,RC Capacity\n" +
+		"Race 1,2030-06-01,18:30,2.5,3\n" +
+		"Bad Date,June 8,,,\n" +
+		"Bad Start,2030-06-15,6pm,,\n" +
+		"Bad Duration,2030-06-22,,two hours,\n" +
+		"Bad Capacity,2030-06-29,,,lots\n" +
+		",,,,\n" +
+		"Race 2,2030-07-06,,2h30m,\n"
+	if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	races, skipped, err := readRaceEvents(file)
+	if err != nil {
+		t.Fatalf("reading races: %v", err)
+	}
+	if skipped != 4 {
+		t.Errorf("expected 4 skipped rows, got %v", skipped)
+	}
+	if len(races) != 2 || races[0].Name != "Race 1" || races[1].Name != "Race 2" {
+		t.Fatalf("unexpected races %v", races)
+	}
+	if races[0].DurationMinutes != 150 || races[1].DurationMinutes != 150 {
+		t.Errorf("unexpected durations %v and %v", races[0].DurationMinutes, races[1].DurationMinutes)
+	}
+	if len(races[0].Capacities) != 1 || races[0].Capacities[0].Limit != 3 {
+		t.Errorf("expected an RC capacity of 3, got %v", races[0].Capacities)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notifier sends email notifications to members
+type Notifier interface {
+	Send(message EmailMessage) error
+}
+
+// EmailMessage is a plain text email to a single recipient
+type EmailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// SMTPConfig defines the mail server used to send notifications
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// EmailTemplate defines the subject and body of a notification, each as a text/template
+type EmailTemplate struct {
+	Subject string
+	Body    string
+}
+
+// notificationData is the data available to the email templates
+type notificationData struct {
+	Name             string
+	Email            string
+	Race             string
+	Date             string
+	Start            string
+	Location         string
+	Role             string
+	Boat             string
+	Outcome          string
+	WaitlistPosition int
+	DrawTime         string
+	Action           string
+	Link             string
+	DaysUntil        int
+	Others           []string
+	Reason           string
+}
+
+var defaultEmailTemplates = map[string]EmailTemplate{
+	outcomeAccepted: {
+		Subject: "Signed up for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nYou are signed up for {{.Role}} on {{.Race}} on {{.Date}} at {{.Start}}{{if .Location}} at {{.Location}}{{end}}.{{if .Boat}} Your boat is {{.Boat}}.{{end}} A calendar invite will follow.\n",
+	},
+	outcomeWaitlisted: {
+		Subject: "Waitlisted for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\n{{.Role}} for {{.Race}} on {{.Date}} is full, so you have been added to the waitlist at position {{.WaitlistPosition}}. You will be notified if a space opens up.\n",
+	},
+	outcomeCancelled: {
+		Subject: "Cancelled signup for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nYour {{.Role}} signup for {{.Race}} on {{.Date}} has been cancelled.\n",
+	},
+	outcomePromoted: {
+		Subject: "A space opened up for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nA space opened up and you have been moved from the waitlist to {{.Role}} for {{.Race}} on {{.Date}} at {{.Start}}{{if .Location}} at {{.Location}}{{end}}.{{if .Boat}} Your boat is {{.Boat}}.{{end}} A calendar invite will follow.\n",
+	},
+	outcomeRejectedNotMember: {
+		Subject: "Unable to sign up for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nWe could not sign you up for {{.Role}} on {{.Race}} because {{.Email}} is not on the membership list. Please contact the club if you believe this is a mistake.\n",
+	},
+	outcomeRejectedMembership: {
+		Subject: "Unable to sign up for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nWe could not sign you up for {{.Role}} on {{.Race}} because your membership does not allow it. {{.Role}} may require a particular membership type, or a membership that is valid through {{.Date}}. Please contact the club if you believe this is a mistake.\n",
+	},
+	outcomeRejectedQuota: {
+		Subject: "Unable to sign up for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nWe could not sign you up for {{.Role}} on {{.Race}} on {{.Date}} because you have reached the limit of {{.Role}} races you may sign up for this season or at once. Spaces are limited so that every member has a chance to sign up.\n",
+	},
+	outcomeRejectedDuties: {
+		Subject: "Unable to sign up for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nWe could not sign you up for {{.Role}} on {{.Race}} on {{.Date}} because you have not yet served or signed up for the duties you owe this season. Please sign up for your duties and then try again.\n",
+	},
+	outcomeRejectedCredits: {
+		Subject: "Unable to sign up for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nWe could not sign you up for {{.Role}} on {{.Race}} on {{.Date}} because you do not have enough credits. Credits are earned by volunteering, such as serving on RC.\n",
+	},
+	outcomeLotteryEntered: {
+		Subject: "Entered in the lottery for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nYour request for {{.Role}} on {{.Race}} on {{.Date}} has been entered in the lottery, which will be drawn after {{.DrawTime}}. You will be notified of the result.\n",
+	},
+	emailLotteryAccepted: {
+		Subject: "You won the lottery for {{.Race}}",
+		Body:    "Hi {{.Name}},\n\nYou were drawn in the lottery and are signed up for {{.Role}} on {{.Race}} on {{.Date}} at {{.Start}}{{if .Location}} at {{.Location}}{{end}}.{{if .Boat}} Your boat is {{.Boat}}.{{end}} A calendar invite will follow.\n",
+	},
+	emailLotteryWaitlisted: {
+		Subject: "Waitlisted for {{.Race}} after the lottery",
+		Body:    "Hi {{.Name}},\n\nYou were not drawn for one of the spaces for {{.Role}} on {{.Race}} on {{.Date}}, so you have been added to the waitlist at position {{.WaitlistPosition}}. You will be notified if a space opens up.\n",
+	},
+	emailWebConfirm: {
+		Subject: "Confirm your {{.Role}} request",
+		Body:    "Hi {{.Name}},\n\nPlease confirm that you want to {{if eq .Action \"cancel\"}}cancel your {{.Role}} signup for{{else}}sign up for {{.Role}} on{{end}} {{.Race}} by opening this link within 24 hours:\n\n{{.Link}}\n\nIf you did not make this request, you can ignore this email.\n",
+	},
+	emailRaceCancelled: {
+		Subject: "{{.Race}} on {{.Date}} is cancelled",
+		Body:    "Hi {{.Name}},\n\n{{.Race}} on {{.Date}}, which you are signed up for as {{.Role}}, has been cancelled{{if .Reason}}: {{.Reason}}{{else}}.{{end}}\n",
+	},
+	emailReminder: {
+		Subject: "Reminder: {{.Race}} on {{.Date}}",
+		Body:    "Hi {{.Name}},\n\nThis is a reminder that you are signed up for {{.Role}} on {{.Race}} on {{.Date}} at {{.Start}}{{if .Location}} at {{.Location}}{{end}}.{{if .Boat}} Your boat is {{.Boat}}.{{end}}\n{{if .Others}}\nAlso signed up:\n{{range .Others}}  {{.}}\n{{end}}{{end}}",
+	},
+}
+
+// Returns the notifier for the configured mail server, or nil if no server is configured
+func (config ProgramConfig) newNotifier() Notifier {
+	if len(config.SMTP.Host) == 0 {
+		return nil
+	}
+	return &smtpNotifier{config: config.SMTP}
+}
+
+// Returns the template for an outcome or reminder, using the configured template if there
+// is one. Emails without a template, or with a template with an empty body, are not sent.
+func (config ProgramConfig) emailTemplate(name string) (EmailTemplate, bool) {
+	tmpl, exists := config.EmailTemplates[name]
+	if !exists {
+		tmpl, exists = defaultEmailTemplates[name]
+	}
+	return tmpl, exists && len(tmpl.Body) > 0
+}
+
+func newNotificationData(progConfig ProgramConfig, user *User, race *Race, roleLabel string, outcome string) notificationData {
+	return notificationData{
+		Name:     user.Name,
+		Email:    user.Email,
+		Race:     race.Name,
+		Date:     race.Date,
+		Start:    race.startTime(progConfig).Format(time.Kitchen),
+		Location: race.location(progConfig),
+		Role:     roleLabel,
+		Outcome:  outcome,
+		Others:   []string{},
+	}
+}
+
+// Builds the notification for a signup outcome, returning false if the outcome is not sent
+func newSignupMessage(progConfig ProgramConfig, formConfig FormConfig, user *User, race *Race, outcome string) (EmailMessage, bool) {
+	return progConfig.newMessage(outcome, newSignupData(progConfig, formConfig, user, race, outcome))
+}
+
+// Returns the notification data for a signup outcome, including the assigned boat, the
+// waitlist position, and the lottery draw time for lottery forms
+func newSignupData(progConfig ProgramConfig, formConfig FormConfig, user *User, race *Race, outcome string) notificationData {
+	data := newNotificationData(progConfig, user, race, formConfig.Label, outcome)
+	if boat := race.boatFor(formConfig.Role, user.ID); boat != nil {
+		data.Boat = boat.label()
+	}
+	for i, entry := range formConfig.getWaitlist(race) {
+		if entry.UserID == user.ID {
+			data.WaitlistPosition = i + 1
+		}
+	}
+	if formConfig.LotteryDrawHours > 0 {
+		data.DrawTime = formConfig.lotteryDrawTime(progConfig, race).Format("Mon Jan 2 3:04PM")
+	}
+
+	return data
+}
+
+// Renders the template with the given name for the recipient in the data, returning
+// false if the template is disabled
+func (config ProgramConfig) newMessage(name string, data notificationData) (EmailMessage, bool) {
+	tmpl, exists := config.emailTemplate(name)
+	if !exists {
+		return EmailMessage{}, false
+	}
+
+	return EmailMessage{
+		To:      data.Email,
+		Subject: renderEmailTemplate(name, tmpl.Subject, data),
+		Body:    renderEmailTemplate(name, tmpl.Body, data),
+	}, true
+}
+
+func renderEmailTemplate(name string, text string, data notificationData) string {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		log.Fatalf("Unable to parse %v email template: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Fatalf("Unable to render %v email template: %v", name, err)
+	}
+	return buf.String()
+}
+
+// Sends the messages, logging any that fail so that a mail server problem does not stop the sync
+func sendMessages(notifier Notifier, messages []EmailMessage) {
+	if notifier == nil {
+		return
+	}
+
+	for _, message := range messages {
+		if err := notifier.Send(message); err != nil {
+			log.Printf("Unable to send '%v' to %v: %v\n", message.Subject, message.To, err)
+		}
+	}
+}
+
+type smtpNotifier struct {
+	config SMTPConfig
+}
+
+func (notifier *smtpNotifier) Send(message EmailMessage) error {
+	port := notifier.config.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(notifier.config.Host, fmt.Sprint(port))
+
+	var auth smtp.Auth = nil
+	if len(notifier.config.Username) > 0 {
+		auth = smtp.PlainAuth("", notifier.config.Username, notifier.config.Password, notifier.config.Host)
+	}
+
+	headers := []string{
+		fmt.Sprintf("From: %v", notifier.config.From),
+		fmt.Sprintf("To: %v", message.To),
+		fmt.Sprintf("Subject: %v", strings.Join(strings.Fields(message.Subject), " ")),
+		fmt.Sprintf("Date: %v", time.Now().Format(time.RFC1123Z)),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	body := strings.ReplaceAll(message.Body, "\n", "\r\n")
+	msg := []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body)
+
+	return smtp.SendMail(addr, auth, notifier.config.From, []string{message.To}, msg)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Accepts a single SMTP session on a local port, returning the port and the raw message
+// data sent in the session
+func newTestSMTPServer(t *testing.T) (int, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		reply := func(line string) {
+			rw.WriteString(line + "\r\n")
+			rw.Flush()
+		}
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 end data with <CR><LF>.<CR><LF>")
+				data := ""
+				for {
+					line, err := rw.ReadString('\n')
+					if err != nil {
+						return
+					} else if line == ".\r\n" {
+						break
+					}
+					data += line
+				}
+				received <- data
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPNotifierSend(t *testing.T) {
+	port, received := newTestSMTPServer(t)
+	notifier := &smtpNotifier{config: SMTPConfig{Host: "127.0.0.1", Port: port, From: "signups@example.org"}}
+
+	err := notifier.Send(EmailMessage{
+		To:      "member@example.org",
+		Subject: "Signed up for\nRace 1",
+		Body:    "Hi Member,\n\nYou are signed up.\n",
+	})
+	if err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+
+	data := <-received
+	headers, body, found := strings.Cut(data, "\r\n\r\n")
+	if !found {
+		t.Fatalf("expected a blank line after the headers, got %q", data)
+	}
+	for _, header := range []string{"From: signups@example.org", "To: member@example.org", "Subject: Signed up for Race 1"} {
+		if !strings.Contains("\r\n"+headers+"\r\n", "\r\n"+header+"\r\n") {
+			t.Errorf("expected the header %q in %q", header, headers)
+		}
+	}
+	if body != "Hi Member,\r\n\r\nYou are signed up.\r\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if strings.Count(data, "\n") != strings.Count(data, "\r\n") {
+		t.Errorf("expected CRLF line endings, got %q", data)
+	}
+}
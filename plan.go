@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/forms/v1"
+)
+
+// SyncPlan records the changes a sync would make without applying them
+type SyncPlan struct {
+	Responses      []string
+	FormChanges    []string
+	CalendarEvents []string
+	Emails         []string
+}
+
+func newSyncPlan() *SyncPlan {
+	return &SyncPlan{
+		Responses:      []string{},
+		FormChanges:    []string{},
+		CalendarEvents: []string{},
+		Emails:         []string{},
+	}
+}
+
+// Records a form response that would be applied - a nil plan records nothing
+func (plan *SyncPlan) recordResponse(tableName string, email string, action string, raceName string) {
+	if plan == nil {
+		return
+	}
+	plan.Responses = append(plan.Responses, fmt.Sprintf("%v: %v %v for %v", tableName, email, action, raceName))
+}
+
+func (plan *SyncPlan) recordCalendarEvent(action string, eventID string, event *calendar.Event) {
+	if plan == nil {
+		return
+	}
+
+	attendees := []string{}
+	for _, a := range event.Attendees {
+		attendees = append(attendees, fmt.Sprintf("%v <%v>", a.DisplayName, a.Email))
+	}
+	if len(attendees) == 0 {
+		attendees = append(attendees, "none")
+	}
+
+	start := ""
+	if event.Start != nil {
+		start = event.Start.DateTime
+	}
+
+	entry := fmt.Sprintf("%v '%v' at %v", action, event.Summary, start)
+	if len(eventID) > 0 {
+		entry = fmt.Sprintf("%v (event %v)", entry, eventID)
+	}
+	entry = fmt.Sprintf("%v\n      attendees: %v\n      description: %v", entry, strings.Join(attendees, ", "), strings.ReplaceAll(event.Description, "\n", " | "))
+
+	plan.CalendarEvents = append(plan.CalendarEvents, entry)
+}
+
+// Writes the plan in a human readable format
+func (plan *SyncPlan) write(w io.Writer) {
+	section := func(title string, entries []string) {
+		fmt.Fprintf(w, "%v:\n", title)
+		if len(entries) == 0 {
+			fmt.Fprintf(w, "  (no changes)\n")
+		}
+		for _, e := range entries {
+			fmt.Fprintf(w, "  %v\n", e)
+		}
+	}
+
+	fmt.Fprintf(w, "Sync plan (dry run - no changes applied)\n")
+	section("Responses to apply", plan.Responses)
+	section("Form option changes", plan.FormChanges)
+	section("Calendar events", plan.CalendarEvents)
+	section("Emails", plan.Emails)
+}
+
+// Wraps a FormStore to record option changes in the plan instead of updating the form
+type planFormStore struct {
+	store           FormStore
+	plan            *SyncPlan
+	originalOptions map[string]map[int64][]string
+}
+
+func newPlanFormStore(store FormStore, plan *SyncPlan) *planFormStore {
+	return &planFormStore{
+		store:           store,
+		plan:            plan,
+		originalOptions: map[string]map[int64][]string{},
+	}
+}
+
+func optionLabels(item *forms.Item) []string {
+	labels := []string{}
+	if item.QuestionItem == nil || item.QuestionItem.Question == nil || item.QuestionItem.Question.ChoiceQuestion == nil {
+		return labels
+	}
+	for _, opt := range item.QuestionItem.Question.ChoiceQuestion.Options {
+		labels = append(labels, opt.Value)
+	}
+	return labels
+}
+
+func (store *planFormStore) GetForm(formCode string) (*forms.Form, error) {
+	form, err := store.store.GetForm(formCode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the current options, as the sync modifies the returned items in place
+	options := map[int64][]string{}
+	for i, itm := range form.Items {
+		options[int64(i)] = optionLabels(itm)
+	}
+	store.originalOptions[formCode] = options
+
+	return form, nil
+}
+
+func (store *planFormStore) ListResponses(formCode string) ([]*forms.FormResponse, error) {
+	return store.store.ListResponses(formCode)
+}
+
+func (store *planFormStore) UpdateItem(formCode string, item *forms.Item, index int64) error {
+	oldLabels := store.originalOptions[formCode][index]
+	newLabels := optionLabels(item)
+
+	changes := []string{}
+	for _, label := range oldLabels {
+		if !slices.Contains(newLabels, label) {
+			changes = append(changes, fmt.Sprintf("    - %v", label))
+		}
+	}
+	for _, label := range newLabels {
+		if !slices.Contains(oldLabels, label) {
+			changes = append(changes, fmt.Sprintf("    + %v", label))
+		}
+	}
+
+	if len(changes) > 0 {
+		store.plan.FormChanges = append(store.plan.FormChanges, fmt.Sprintf("form %v '%v':\n%v", formCode, item.Title, strings.Join(changes, "\n")))
+	}
+
+	return nil
+}
+
+// Wraps a CalendarStore to record event changes in the plan instead of applying them
+type planCalendarStore struct {
+	store  CalendarStore
+	plan   *SyncPlan
+	nextID int
+}
+
+func newPlanCalendarStore(store CalendarStore, plan *SyncPlan) *planCalendarStore {
+	return &planCalendarStore{store: store, plan: plan}
+}
+
+func (store *planCalendarStore) GetEvent(eventID string) (*calendar.Event, error) {
+	return store.store.GetEvent(eventID)
+}
+
+func (store *planCalendarStore) InsertEvent(event *calendar.Event) (*calendar.Event, error) {
+	store.plan.recordCalendarEvent("create", "", event)
+
+	store.nextID += 1
+	result := *event
+	result.Id = fmt.Sprintf("dry-run-%d", store.nextID)
+	return &result, nil
+}
+
+func (store *planCalendarStore) UpdateEvent(eventID string, event *calendar.Event, notify bool) (*calendar.Event, error) {
+	if notify {
+		store.plan.recordCalendarEvent("update and notify attendees of", eventID, event)
+	} else {
+		store.plan.recordCalendarEvent("update", eventID, event)
+	}
+	return event, nil
+}
+
+func (store *planCalendarStore) DeleteEvent(eventID string) error {
+	event, err := store.store.GetEvent(eventID)
+	if err != nil {
+		return err
+	}
+
+	store.plan.recordCalendarEvent("delete", eventID, event)
+	return nil
+}
+
+func (store *planCalendarStore) NotifiesAttendees() bool {
+	return store.store.NotifiesAttendees()
+}
+
+// Records emails in the plan instead of sending them
+type planNotifier struct {
+	plan *SyncPlan
+}
+
+// Returns a notifier recording to the plan, or nil if the wrapped notifier is not configured
+func newPlanNotifier(notifier Notifier, plan *SyncPlan) Notifier {
+	if notifier == nil {
+		return nil
+	}
+	return &planNotifier{plan: plan}
+}
+
+func (notifier *planNotifier) Send(message EmailMessage) error {
+	to := message.To
+	if len(to) == 0 {
+		to = "webhook"
+	}
+	notifier.plan.Emails = append(notifier.plan.Emails, fmt.Sprintf("to %v: %v", to, message.Subject))
+	return nil
+}
+
+// Returns services that record writes in a new plan rather than applying them
+func (services SyncServices) withPlan() SyncServices {
+	plan := newSyncPlan()
+	return SyncServices{
+		Forms:      newPlanFormStore(services.Forms, plan),
+		Calendar:   newPlanCalendarStore(services.Calendar, plan),
+		Membership: services.Membership,
+		Notifier:   newPlanNotifier(services.Notifier, plan),
+		Webhook:    newPlanNotifier(services.Webhook, plan),
+		Plan:       plan,
+	}
+}
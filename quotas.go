@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Returns the races, other than cancelled races, that the user is signed up or
+// waitlisted for in the role, in date order
+func memberRaces(db *gorm.DB, role string, userID uint) ([]*Race, error) {
+	races := []*Race{}
+	err := db.Where("cancelled = ?", false).
+		Where("id IN (?) OR id IN (?)",
+			db.Model(&RaceSignup{}).Select("race_id").Where("user_id = ? AND role = ?", userID, role),
+			db.Model(&WaitlistEntry{}).Select("race_id").Where("user_id = ? AND role = ?", userID, role)).
+		Order("date").Order("name").Find(&races).Error
+	return races, err
+}
+
+// Returns the season of a race, which is the year of the race date
+func raceSeason(race *Race) string {
+	season, _, _ := strings.Cut(race.Date, "-")
+	return season
+}
+
+// Returns the number of races in the season and the number of races on or after
+// today in the list of races
+func countQuotaUsage(races []*Race, season string, today string) (int, int) {
+	seasonCount, upcomingCount := 0, 0
+	for _, race := range races {
+		if raceSeason(race) == season {
+			seasonCount += 1
+		}
+		if race.Date >= today {
+			upcomingCount += 1
+		}
+	}
+	return seasonCount, upcomingCount
+}
+
+// Returns true if signing the user up for the race would take them over the
+// MaxPerSeason or MaxConcurrent limits of the form's role. Waitlist entries count
+// towards the limits, and signups for races the user is already on are never over.
+func (config FormConfig) overQuota(db *gorm.DB, race *Race, user *User, currentTime time.Time) (bool, error) {
+	if config.MaxPerSeason <= 0 && config.MaxConcurrent <= 0 {
+		return false, nil
+	}
+
+	races, err := memberRaces(db, config.Role, user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range races {
+		if r.ID == race.ID {
+			return false, nil
+		}
+	}
+
+	seasonCount, upcomingCount := countQuotaUsage(races, raceSeason(race), currentTime.Format(time.DateOnly))
+	if config.MaxPerSeason > 0 && seasonCount >= config.MaxPerSeason {
+		log.Printf("%v has reached the %v limit of %v races for %v\n", user.Email, config.Role, config.MaxPerSeason, raceSeason(race))
+		return true, nil
+	} else if config.MaxConcurrent > 0 && upcomingCount >= config.MaxConcurrent {
+		log.Printf("%v has reached the %v limit of %v upcoming races\n", user.Email, config.Role, config.MaxConcurrent)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Formats a usage count along with its limit, if there is one
+func quotaText(count int, limit int) string {
+	if limit > 0 {
+		return fmt.Sprintf("%v/%v", count, limit)
+	}
+	return fmt.Sprint(count)
+}
+
+// quotaUsage is the races a member has signed up or waitlisted for in a role, along
+// with the signups rejected for being over the limits
+type quotaUsage struct {
+	User     *User
+	Season   int
+	Upcoming int
+	Rejected int64
+}
+
+// Returns the usage of each member with races or quota rejections in the role for the
+// season, counting upcoming races from today, in email order
+func findQuotaUsage(db *gorm.DB, role string, season string, today string) ([]quotaUsage, error) {
+	users := []*User{}
+	err := db.Where("id IN (?) OR id IN (?) OR email IN (?)",
+		db.Model(&RaceSignup{}).Select("user_id").Where("role = ?", role),
+		db.Model(&WaitlistEntry{}).Select("user_id").Where("role = ?", role),
+		db.Model(&SignupEvent{}).Select("email").Where("role = ? AND outcome = ?", role, outcomeRejectedQuota)).
+		Order("email").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	usage := []quotaUsage{}
+	for _, user := range users {
+		races, err := memberRaces(db, role, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		seasonCount, upcomingCount := countQuotaUsage(races, season, today)
+
+		var rejected int64
+		err = db.Model(&SignupEvent{}).
+			Where("email = ? AND role = ? AND outcome = ?", user.Email, role, outcomeRejectedQuota).
+			Where("race_id IN (?)", db.Model(&Race{}).Select("id").Where("date LIKE ?", season+"-%")).
+			Count(&rejected).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if seasonCount > 0 || upcomingCount > 0 || rejected > 0 {
+			usage = append(usage, quotaUsage{User: user, Season: seasonCount, Upcoming: upcomingCount, Rejected: rejected})
+		}
+	}
+	return usage, nil
+}
+
+// Prints the number of races each member has signed up or waitlisted for in the
+// season and upcoming, along with the signups rejected for being over the limits
+func runUsageCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("usage", flag.ExitOnError)
+	roleName := flags.String("role", "", "only show usage for the given role - shows roles with limits if not provided")
+	season := flags.String("season", fmt.Sprint(time.Now().Year()), "season to count races for")
+	flags.Parse(args)
+
+	db := progConfig.openDatabase()
+
+	roles := []RoleConfig{}
+	if len(*roleName) > 0 {
+		role, exists := progConfig.findRole(*roleName)
+		if !exists {
+			log.Fatalf("Unknown role '%v'", *roleName)
+		}
+		roles = append(roles, role)
+	} else {
+		for _, role := range progConfig.roles() {
+			if role.MaxPerSeason > 0 || role.MaxConcurrent > 0 {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	today := time.Now().In(progConfig.timezone()).Format(time.DateOnly)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROLE\tEMAIL\tNAME\tSEASON\tUPCOMING\tREJECTED")
+	for _, role := range roles {
+		usage, err := findQuotaUsage(db, role.Name, *season, today)
+		if err != nil {
+			log.Fatalf("Error getting database quota usage: %v", err)
+		}
+
+		for _, entry := range usage {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", role.Name, entry.User.Email, entry.User.Name, quotaText(entry.Season, role.MaxPerSeason), quotaText(entry.Upcoming, role.MaxConcurrent), entry.Rejected)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Creates a race on the date, signing the user up for it in the role if given
+func createQuotaRace(t *testing.T, db *gorm.DB, date string, user *User, role string) *Race {
+	t.Helper()
+
+	race := &Race{Name: "Race " + date, Date: date}
+	if err := db.Create(race).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user != nil {
+		db.Create(&RaceSignup{RaceID: race.ID, UserID: user.ID, Role: role})
+	}
+	return race
+}
+
+func TestOverQuotaPerSeason(t *testing.T) {
+	progConfig := ProgramConfig{Roles: []RoleConfig{{Name: "Renters", EntryLimit: 5, MaxPerSeason: 3}}}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	users := []UserEntry{{Email: "a@example.org", Name: "A"}}
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+	config, _ := progConfig.formConfigForRole("Renters", &users)
+	currentTime := time.Date(2030, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	createQuotaRace(t, db, "2030-05-01", user, "Renters")
+	createQuotaRace(t, db, "2030-05-15", user, "Renters")
+	createQuotaRace(t, db, "2029-07-01", user, "Renters")
+	createQuotaRace(t, db, "2030-07-02", user, "RC")
+	joined := createQuotaRace(t, db, "2030-07-01", nil, "")
+	db.Create(&WaitlistEntry{RaceID: joined.ID, UserID: user.ID, Role: "Renters"})
+
+	// Past races and waitlist entries count towards the season, other seasons and roles do not
+	if over, err := config.overQuota(db, createQuotaRace(t, db, "2030-08-01", nil, ""), user, currentTime); err != nil || !over {
+		t.Errorf("expected a fourth race in the season to be over the quota, got %v, %v", over, err)
+	}
+	if over, err := config.overQuota(db, joined, user, currentTime); err != nil || over {
+		t.Errorf("expected a race the user is already on to never be over the quota, got %v, %v", over, err)
+	}
+	if over, err := config.overQuota(db, createQuotaRace(t, db, "2031-05-01", nil, ""), user, currentTime); err != nil || over {
+		t.Errorf("expected a race in the next season to be under the quota, got %v, %v", over, err)
+	}
+
+	// Cancelled races no longer count
+	db.Model(&Race{}).Where("id = ?", joined.ID).Update("Cancelled", true)
+	if over, err := config.overQuota(db, createQuotaRace(t, db, "2030-08-15", nil, ""), user, currentTime); err != nil || over {
+		t.Errorf("expected a cancelled race to free up the quota, got %v, %v", over, err)
+	}
+}
+
+func TestOverQuotaConcurrent(t *testing.T) {
+	progConfig := ProgramConfig{Roles: []RoleConfig{{Name: "Renters", EntryLimit: 5, MaxConcurrent: 2}}}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	users := []UserEntry{{Email: "a@example.org", Name: "A"}}
+	user := &User{Email: "a@example.org", Name: "A"}
+	db.Create(user)
+	config, _ := progConfig.formConfigForRole("Renters", &users)
+	currentTime := time.Date(2030, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	createQuotaRace(t, db, "2030-05-01", user, "Renters")
+	createQuotaRace(t, db, "2030-06-14", user, "Renters")
+	createQuotaRace(t, db, "2030-06-15", user, "Renters")
+
+	if over, err := config.overQuota(db, createQuotaRace(t, db, "2030-07-01", nil, ""), user, currentTime); err != nil || over {
+		t.Errorf("expected one upcoming race to be under the limit, got %v, %v", over, err)
+	}
+
+	createQuotaRace(t, db, "2030-06-20", user, "Renters")
+	if over, err := config.overQuota(db, createQuotaRace(t, db, "2030-07-02", nil, ""), user, currentTime); err != nil || !over {
+		t.Errorf("expected a third upcoming race to be over the limit, got %v, %v", over, err)
+	}
+
+	// Once a race has passed it no longer counts as upcoming
+	later := time.Date(2030, 6, 16, 12, 0, 0, 0, time.UTC)
+	if over, err := config.overQuota(db, createQuotaRace(t, db, "2030-07-03", nil, ""), user, later); err != nil || over {
+		t.Errorf("expected a passed race to free up the limit, got %v, %v", over, err)
+	}
+}
+
+func TestFindQuotaUsage(t *testing.T) {
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	a := &User{Email: "a@example.org", Name: "A"}
+	b := &User{Email: "b@example.org", Name: "B"}
+	c := &User{Email: "c@example.org", Name: "C"}
+	db.Create(a)
+	db.Create(b)
+	db.Create(c)
+
+	createQuotaRace(t, db, "2030-05-01", a, "Renters")
+	createQuotaRace(t, db, "2030-07-01", a, "Renters")
+	createQuotaRace(t, db, "2029-07-01", b, "Renters")
+	createQuotaRace(t, db, "2030-07-02", c, "RC")
+
+	rejected := createQuotaRace(t, db, "2030-07-03", nil, "").ID
+	lastSeason := createQuotaRace(t, db, "2029-08-01", nil, "").ID
+	db.Create(&SignupEvent{Email: "b@example.org", RaceID: &rejected, Role: "Renters", Outcome: outcomeRejectedQuota})
+	db.Create(&SignupEvent{Email: "b@example.org", RaceID: &rejected, Role: "Renters", Outcome: outcomeRejectedQuota})
+	db.Create(&SignupEvent{Email: "b@example.org", RaceID: &lastSeason, Role: "Renters", Outcome: outcomeRejectedQuota})
+	db.Create(&SignupEvent{Email: "a@example.org", RaceID: &rejected, Role: "Renters", Outcome: outcomeAccepted})
+
+	usage, err := findQuotaUsage(db, "Renters", "2030", "2030-06-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 {
+		t.Fatalf("expected usage for A and B, got %+v", usage)
+	}
+	if usage[0].User.Email != "a@example.org" || usage[0].Season != 2 || usage[0].Upcoming != 1 || usage[0].Rejected != 0 {
+		t.Errorf("unexpected usage for A: %+v", usage[0])
+	}
+	if usage[1].User.Email != "b@example.org" || usage[1].Season != 0 || usage[1].Upcoming != 0 || usage[1].Rejected != 2 {
+		t.Errorf("expected B to only have the rejections in the season, got %+v", usage[1])
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const emailReminder = "reminder"
+
+// SentReminder records a reminder sent to a user for a race, so that each reminder is only sent once
+type SentReminder struct {
+	gorm.Model
+	RaceID uint
+	UserID uint
+	Role   string
+	Days   int
+}
+
+// Returns the smallest reminder window, in days, that the race start falls within, or
+// false if the race has started or is not yet within any window
+func (config ProgramConfig) reminderWindow(start time.Time, currentTime time.Time) (int, bool) {
+	if !start.After(currentTime) {
+		return 0, false
+	}
+
+	windows := slices.Clone(config.ReminderDays)
+	slices.Sort(windows)
+	for _, days := range windows {
+		if days > 0 && start.Sub(currentTime) <= time.Duration(days)*24*time.Hour {
+			return days, true
+		}
+	}
+	return 0, false
+}
+
+// Emails a reminder to everyone signed up for a race once it is within each of the
+// configured reminder windows. Each reminder is recorded once sent, so that later
+// runs within the same window do not send it again.
+func sendReminders(progConfig ProgramConfig, db *gorm.DB, notifier Notifier, currentTime time.Time) {
+	if _, exists := progConfig.emailTemplate(emailReminder); !exists || notifier == nil || len(progConfig.ReminderDays) == 0 {
+		return
+	}
+
+	today := currentTime.In(progConfig.timezone()).Format(time.DateOnly)
+
+	races := []*Race{}
+	result := preloadSignups(db).Where("date >= ?", today).Where("cancelled = ?", false).Order("date").Order("name").Find(&races)
+	if result.Error != nil {
+		log.Fatalf("Error getting database races: %v", result.Error)
+	}
+
+	for _, race := range races {
+		days, exists := progConfig.reminderWindow(race.startTime(progConfig), currentTime)
+		if !exists {
+			continue
+		}
+
+		for _, signup := range race.Signups {
+			sent := &SentReminder{RaceID: race.ID, UserID: signup.UserID, Role: signup.Role, Days: days}
+			err := db.Where(sent).First(&SentReminder{}).Error
+			if err == nil {
+				continue
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Fatalf("Database error: %v", err)
+			}
+
+			message, _ := newReminderMessage(progConfig, race, signup, currentTime)
+			if err := notifier.Send(message); err != nil {
+				log.Printf("Unable to send reminder for %v to %v: %v\n", race.Name, signup.User.Email, err)
+				continue
+			}
+
+			db.Create(sent)
+			log.Printf("Sent %v day reminder for %v to %v\n", days, race.Name, signup.User.Email)
+		}
+	}
+}
+
+func newReminderMessage(progConfig ProgramConfig, race *Race, signup *RaceSignup, currentTime time.Time) (EmailMessage, bool) {
+	roleLabel := signup.Role
+	if role, exists := progConfig.findRole(signup.Role); exists {
+		roleLabel = role.label()
+	}
+
+	data := newNotificationData(progConfig, signup.User, race, roleLabel, emailReminder)
+	if signup.Boat != nil {
+		data.Boat = signup.Boat.label()
+	}
+	data.DaysUntil = int(race.startTime(progConfig).Sub(currentTime).Hours() / 24)
+
+	for _, other := range race.Signups {
+		if other.ID == signup.ID {
+			continue
+		}
+
+		otherLabel := other.Role
+		if role, exists := progConfig.findRole(other.Role); exists {
+			otherLabel = role.label()
+		}
+		data.Others = append(data.Others, fmt.Sprintf("%v (%v)", other.User.Name, otherLabel))
+	}
+
+	return progConfig.newMessage(emailReminder, data)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReminderWindow(t *testing.T) {
+	config := ProgramConfig{ReminderDays: []int{1, 3}}
+	currentTime := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		until  time.Duration
+		days   int
+		exists bool
+	}{
+		{80 * time.Hour, 0, false},
+		{72 * time.Hour, 3, true},
+		{50 * time.Hour, 3, true},
+		{24 * time.Hour, 1, true},
+		{time.Hour, 1, true},
+		{0, 0, false},
+		{-time.Hour, 0, false},
+	}
+	for _, c := range cases {
+		days, exists := config.reminderWindow(currentTime.Add(c.until), currentTime)
+		if days != c.days || exists != c.exists {
+			t.Errorf("expected %v and %v for a race starting in %v, got %v and %v", c.days, c.exists, c.until, days, exists)
+		}
+	}
+}
+
+func TestSendRemindersOncePerWindow(t *testing.T) {
+	progConfig := ProgramConfig{
+		Roles:        []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 2}},
+		ReminderDays: []int{3, 1},
+	}
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	notifier := &memoryNotifier{}
+
+	race := &Race{Name: "Race 1", Date: "2030-06-10", StartTime: "18:00"}
+	db.Create(race)
+	users := []*User{{Email: "a@example.org", Name: "A"}, {Email: "b@example.org", Name: "B"}}
+	db.Create(users)
+	for _, user := range users {
+		db.Create(&RaceSignup{RaceID: race.ID, UserID: user.ID, Role: "RC"})
+	}
+
+	start := race.startTime(progConfig)
+	for _, until := range []time.Duration{50 * time.Hour, 49 * time.Hour} {
+		sendReminders(progConfig, db, notifier, start.Add(-until))
+	}
+	if len(notifier.Sent) != 2 {
+		t.Fatalf("expected one reminder for each signup in the three day window, got %v", notifier.Sent)
+	}
+	for _, message := range notifier.Sent {
+		if message.To == "a@example.org" && !strings.Contains(message.Body, "B (RC)") {
+			t.Errorf("expected the reminder to list the other signups, got %q", message.Body)
+		}
+	}
+
+	sendReminders(progConfig, db, notifier, start.Add(-20*time.Hour))
+	sendReminders(progConfig, db, notifier, start.Add(-10*time.Hour))
+	if len(notifier.Sent) != 4 {
+		t.Errorf("expected one more reminder for each signup in the one day window, got %v", notifier.Sent)
+	}
+}
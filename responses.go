@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"google.golang.org/api/forms/v1"
+	"gorm.io/gorm"
+)
+
+// ProcessedResponse marks a form response as applied so that it is only processed once.
+// A response that is edited after being processed has a new LastSubmittedTime and is
+// processed again.
+type ProcessedResponse struct {
+	gorm.Model
+	ResponseID        string `gorm:"uniqueIndex"`
+	FormCode          string
+	LastSubmittedTime string
+}
+
+func isResponseProcessed(db *gorm.DB, response *forms.FormResponse) bool {
+	processed := &ProcessedResponse{}
+	err := db.Where(&ProcessedResponse{ResponseID: response.ResponseId}).First(processed).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false
+		}
+		log.Fatalf("Database error: %v", err)
+	}
+
+	return processed.LastSubmittedTime == response.LastSubmittedTime
+}
+
+func markResponseProcessed(db *gorm.DB, formConfig FormConfig, response *forms.FormResponse) error {
+	processed := &ProcessedResponse{}
+	err := db.Where(&ProcessedResponse{ResponseID: response.ResponseId}).FirstOrInit(processed).Error
+	if err != nil {
+		return err
+	}
+
+	processed.ResponseID = response.ResponseId
+	processed.FormCode = formConfig.FormCode
+	processed.LastSubmittedTime = response.LastSubmittedTime
+	return db.Save(processed).Error
+}
+
+// Marks the responses submitted before the last run as processed the first time a
+// form is seen, as these were already applied when runs were tracked by LastRun only
+func seedProcessedResponses(db *gorm.DB, formConfig FormConfig, responses []*forms.FormResponse, lastRun time.Time) {
+	if lastRun.IsZero() {
+		return
+	}
+
+	var count int64
+	if err := db.Model(&ProcessedResponse{}).Where(&ProcessedResponse{FormCode: formConfig.FormCode}).Count(&count).Error; err != nil {
+		log.Fatalf("Database error: %v", err)
+	} else if count > 0 {
+		return
+	}
+
+	seeded := 0
+	for _, response := range responses {
+		submitted, err := time.Parse(time.RFC3339Nano, response.LastSubmittedTime)
+		if err != nil || submitted.After(lastRun) {
+			continue
+		}
+
+		if err := markResponseProcessed(db, formConfig, response); err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+		seeded += 1
+	}
+
+	log.Printf("Marked %v responses before %v as processed for form %v\n", seeded, lastRun.Format(time.DateTime), formConfig.FormCode)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// RoleConfig defines a role that members sign up for on each race, such as RC or rentals
+type RoleConfig struct {
+	Name          string
+	Label         string
+	EntryLimit    int
+	Minimum       int
+	Boats         bool
+	MaxPerSeason  int
+	MaxConcurrent int
+}
+
+// RaceSignup links a user to a race for a single role
+type RaceSignup struct {
+	gorm.Model
+	RaceID uint
+	UserID uint
+	User   *User
+	Role   string
+	BoatID *uint
+	Boat   *Boat
+}
+
+func (role RoleConfig) label() string {
+	if len(role.Label) > 0 {
+		return role.Label
+	}
+	return role.Name
+}
+
+// Returns the configured roles, or the RC and Renters roles built from the legacy
+// FormRC and FormRentals settings if no roles are configured. The legacy Renters limit
+// is AllowedRentersCount when it is set, and the minimums come from the legacy forms.
+func (config ProgramConfig) roles() []RoleConfig {
+	if len(config.Roles) > 0 {
+		return config.Roles
+	}
+
+	rentersLimit := config.FormRentals.EntryLimit
+	if config.AllowedRentersCount > 0 {
+		rentersLimit = config.AllowedRentersCount
+	}
+
+	return []RoleConfig{
+		{Name: "RC", Label: "RC", EntryLimit: config.FormRC.EntryLimit, Minimum: config.FormRC.Minimum},
+		{Name: "Renters", Label: "Renters", EntryLimit: rentersLimit, Minimum: config.FormRentals.Minimum},
+	}
+}
+
+// Returns the role with the given name, ignoring case
+func (config ProgramConfig) findRole(name string) (RoleConfig, bool) {
+	for _, role := range config.roles() {
+		if strings.EqualFold(role.Name, name) {
+			return role, true
+		}
+	}
+	return RoleConfig{}, false
+}
+
+// Returns the configured forms, or the legacy FormRC and FormRentals forms if no forms are configured
+func (config ProgramConfig) forms() []ProgramConfigForm {
+	if len(config.Forms) > 0 {
+		return config.Forms
+	}
+
+	forms := []ProgramConfigForm{}
+	for _, f := range []ProgramConfigForm{config.FormRC, config.FormRentals} {
+		if len(f.roleName()) > 0 {
+			forms = append(forms, f)
+		}
+	}
+	return forms
+}
+
+// Returns the signups for the role in signup order
+func (race Race) signupsFor(role string) []*RaceSignup {
+	signups := []*RaceSignup{}
+	for _, signup := range race.Signups {
+		if signup.Role == role {
+			signups = append(signups, signup)
+		}
+	}
+	return signups
+}
+
+// Returns the users signed up for the role in signup order
+func (race Race) usersFor(role string) []*User {
+	users := []*User{}
+	for _, signup := range race.signupsFor(role) {
+		users = append(users, signup.User)
+	}
+	return users
+}
+
+// Updates the users signed up for the role to match the given list, keeping the
+// existing signups of users that remain in the list
+func (race *Race) saveUsersFor(db *gorm.DB, role string, users []*User) error {
+	keep := map[uint]bool{}
+	for _, u := range users {
+		keep[u.ID] = true
+	}
+
+	existing := map[uint]bool{}
+	signups := []*RaceSignup{}
+	for _, signup := range race.Signups {
+		if signup.Role != role {
+			signups = append(signups, signup)
+		} else if keep[signup.UserID] && !existing[signup.UserID] {
+			existing[signup.UserID] = true
+			signups = append(signups, signup)
+		} else if err := db.Delete(signup).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, u := range users {
+		if !existing[u.ID] {
+			existing[u.ID] = true
+			signup := &RaceSignup{RaceID: race.ID, UserID: u.ID, User: u, Role: role}
+			if err := db.Omit("User", "Boat").Create(signup).Error; err != nil {
+				return err
+			}
+			signups = append(signups, signup)
+		}
+	}
+
+	race.Signups = signups
+	return nil
+}
+
+// Adds the signups, in signup order, to the races loaded by the query
+func preloadSignups(db *gorm.DB) *gorm.DB {
+	return db.Preload("Signups", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Signups.User").Preload("Signups.Boat")
+}
+
+// Moves the signups from the join tables used before roles were configurable into
+// the race signups table, keeping the order they were added in
+func migrateRoles(db *gorm.DB) {
+	legacyTables := map[string]string{
+		"user_rc_races":     "RC",
+		"user_rental_races": "Renters",
+	}
+
+	for table, role := range legacyTables {
+		if !db.Migrator().HasTable(table) {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec("INSERT INTO race_signups (created_at, updated_at, race_id, user_id, role) SELECT CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, race_id, user_id, ? FROM "+table+" ORDER BY rowid", role).Error
+			if err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(table)
+		})
+		if err != nil {
+			log.Fatalf("Unable to migrate %v to race signups: %v", table, err)
+		}
+
+		log.Printf("Migrated %v to race signups for role %v\n", table, role)
+	}
+}
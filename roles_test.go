@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLegacyRolesUseAllowedRentersCount(t *testing.T) {
+	config := ProgramConfig{
+		FormRC:              ProgramConfigForm{TableName: "RC", EntryLimit: 3},
+		FormRentals:         ProgramConfigForm{TableName: "Renters", EntryLimit: -1},
+		AllowedRentersCount: 4,
+	}
+
+	renters, exists := config.findRole("Renters")
+	if !exists || renters.EntryLimit != 4 {
+		t.Errorf("expected a Renters limit of 4, got %v", renters.EntryLimit)
+	}
+
+	config.AllowedRentersCount = 0
+	renters, _ = config.findRole("Renters")
+	if renters.EntryLimit != -1 {
+		t.Errorf("expected the FormRentals limit without AllowedRentersCount, got %v", renters.EntryLimit)
+	}
+
+	config.Roles = []RoleConfig{{Name: "Renters", EntryLimit: 2}}
+	config.AllowedRentersCount = 4
+	renters, _ = config.findRole("Renters")
+	if renters.EntryLimit != 2 {
+		t.Errorf("expected the configured role limit, got %v", renters.EntryLimit)
+	}
+}
+
+func TestMigrateRolesKeepsSignupOrder(t *testing.T) {
+	db := openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite"))
+	race := &Race{Name: "Race 1", Date: "2030-06-01"}
+	db.Create(race)
+	users := []*User{{Email: "a@example.org"}, {Email: "b@example.org"}, {Email: "c@example.org"}}
+	db.Create(users)
+
+	// The old join table is keyed by user, and its index is smaller to scan than the
+	// rows, so only the rowid keeps the order the signups were added in
+	db.Exec("CREATE TABLE user_rc_races (user_id integer, race_id integer, note text, PRIMARY KEY (user_id, race_id))")
+	for _, i := range []int{2, 0, 1} {
+		db.Exec("INSERT INTO user_rc_races (user_id, race_id, note) VALUES (?, ?, ?)", users[i].ID, race.ID, strings.Repeat("x", 1000))
+	}
+
+	migrateRoles(db)
+
+	loaded, err := loadRace(db, race.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emails := []string{}
+	for _, user := range loaded.usersFor("RC") {
+		emails = append(emails, user.Email)
+	}
+	if fmt.Sprint(emails) != "[c@example.org a@example.org b@example.org]" {
+		t.Errorf("expected the signups in the order they were added, got %v", emails)
+	}
+	if db.Migrator().HasTable("user_rc_races") {
+		t.Errorf("expected the old join table to be dropped")
+	}
+}
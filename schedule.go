@@ -0,0 +1,267 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Updates the races in the database to match the race schedule CSV. Rows that match a
+// race by name and date update its details, rows that match an upcoming race by name
+// only move it to the new date, and rows that match an upcoming race by date only
+// rename it, so the signups and calendar event carry over. Other rows are added as new
+// races, and upcoming races no longer in the schedule are removed along with their
+// calendar events. Races that have already happened are never moved or removed.
+// Returns the emails for the users promoted from the waitlist by capacity changes.
+func reconcileRaceSchedule(db *gorm.DB, config ProgramConfig, calStore CalendarStore) []EmailMessage {
+	messages := []EmailMessage{}
+	entries, skipped, err := readRaceEvents(config.racesFile())
+	if err != nil {
+		log.Printf("Unable to read race schedule, skipping schedule changes: %v\n", err)
+		return messages
+	}
+
+	ignored := map[string]bool{}
+	for _, entry := range entries {
+		capacities := []*RaceCapacity{}
+		for _, capacity := range entry.Capacities {
+			if role, exists := config.scheduleRole(capacity.Role, "capacity", ignored); exists {
+				capacity.Role = role
+				capacities = append(capacities, capacity)
+			}
+		}
+		entry.Capacities = capacities
+
+		minimums := []*RaceMinimum{}
+		for _, minimum := range entry.Minimums {
+			if role, exists := config.scheduleRole(minimum.Role, "minimum", ignored); exists {
+				minimum.Role = role
+				minimums = append(minimums, minimum)
+			}
+		}
+		entry.Minimums = minimums
+	}
+
+	races := []*Race{}
+	if err := db.Preload("Capacities").Preload("Minimums").Order("date").Order("name").Find(&races).Error; err != nil {
+		log.Fatalf("Error getting database races: %v", err)
+	}
+
+	matched := map[uint]bool{}
+	unmatched := []*Race{}
+	for _, entry := range entries {
+		race := findScheduledRace(races, matched, func(r *Race) bool {
+			return r.Name == entry.Name && r.Date == entry.Date
+		})
+		if race != nil {
+			matched[race.ID] = true
+			messages = append(messages, updateRaceDetails(db, config, race, entry)...)
+		} else {
+			unmatched = append(unmatched, entry)
+		}
+	}
+
+	today := time.Now().In(config.timezone()).Format(time.DateOnly)
+	upcoming := func(r *Race) bool {
+		return r.Date >= today
+	}
+
+	// Moved races keep their name, and renamed races keep their date. Both are only
+	// matched when there is a single candidate on each side.
+	unmatched = matchScheduleEntries(unmatched, func(entry *Race) bool {
+		race := findScheduledRace(races, matched, func(r *Race) bool {
+			return upcoming(r) && r.Name == entry.Name
+		})
+		if race == nil || countEntries(unmatched, func(e *Race) bool { return e.Name == entry.Name }) != 1 {
+			return false
+		}
+
+		log.Printf("Moved %s from %s to %s\n", race.Name, race.Date, entry.Date)
+		matched[race.ID] = true
+		messages = append(messages, moveRace(db, config, race, entry)...)
+		return true
+	})
+
+	unmatched = matchScheduleEntries(unmatched, func(entry *Race) bool {
+		race := findScheduledRace(races, matched, func(r *Race) bool {
+			return upcoming(r) && r.Date == entry.Date
+		})
+		if race == nil || countEntries(unmatched, func(e *Race) bool { return e.Date == entry.Date }) != 1 {
+			return false
+		}
+
+		log.Printf("Renamed %s on %s to %s\n", race.Name, race.Date, entry.Name)
+		matched[race.ID] = true
+		messages = append(messages, moveRace(db, config, race, entry)...)
+		return true
+	})
+
+	for _, entry := range unmatched {
+		log.Printf("Adding new race %s on %s\n", entry.Name, entry.Date)
+		db.Create(entry)
+	}
+
+	if len(entries) == 0 {
+		log.Printf("Race schedule is empty, not removing any races\n")
+		return messages
+	} else if skipped > 0 {
+		// A skipped row may be a race that is still scheduled, so its signups are kept
+		log.Printf("Skipped %v race schedule rows, not removing any races\n", skipped)
+		return messages
+	}
+
+	for _, race := range races {
+		if !matched[race.ID] && upcoming(race) {
+			messages = append(messages, removeRace(db, config, calStore, race)...)
+		}
+	}
+	return messages
+}
+
+// Returns the single race not yet matched that satisfies the filter, or nil if there
+// are none or more than one
+func findScheduledRace(races []*Race, matched map[uint]bool, filter func(*Race) bool) *Race {
+	var found *Race = nil
+	for _, race := range races {
+		if matched[race.ID] || !filter(race) {
+			continue
+		} else if found != nil {
+			return nil
+		}
+		found = race
+	}
+	return found
+}
+
+func countEntries(entries []*Race, filter func(*Race) bool) int {
+	count := 0
+	for _, entry := range entries {
+		if filter(entry) {
+			count += 1
+		}
+	}
+	return count
+}
+
+// Returns the entries that the match function did not match to an existing race
+func matchScheduleEntries(entries []*Race, match func(*Race) bool) []*Race {
+	remaining := []*Race{}
+	for _, entry := range entries {
+		if !match(entry) {
+			remaining = append(remaining, entry)
+		}
+	}
+	return remaining
+}
+
+// Moves an existing race to the name and date of the schedule entry, keeping its
+// signups, waitlist, and calendar event
+func moveRace(db *gorm.DB, config ProgramConfig, race *Race, entry *Race) []EmailMessage {
+	race.Name = entry.Name
+	race.Date = entry.Date
+	race.CalendarOutdated = true
+	db.Omit("Capacities", "Minimums").Save(race)
+
+	return updateRaceDetails(db, config, race, entry)
+}
+
+// Removes a race that is no longer in the schedule along with its calendar event, which
+// notifies everyone signed up. They are emailed instead if the calendar cannot notify
+// attendees or the race has no event, unless the race was already cancelled. The race is
+// soft deleted so that its signups remain in the database. Returns the emails for the signups.
+func removeRace(db *gorm.DB, config ProgramConfig, calStore CalendarStore, race *Race) []EmailMessage {
+	notified := race.Cancelled
+	if race.EventID != nil {
+		if err := calStore.DeleteEvent(*race.EventID); err != nil {
+			log.Printf("Unable to delete calendar event %v for %v: %v\n", *race.EventID, race.Name, err)
+		} else {
+			log.Printf("Deleted calendar event for %v\n", race.Name)
+			notified = notified || calStore.NotifiesAttendees()
+		}
+	}
+
+	messages := []EmailMessage{}
+	if !notified {
+		signedUp, err := loadRace(db, race.ID)
+		if err != nil {
+			log.Fatalf("Database error: %v", err)
+		}
+		signedUp.CancelReason = "removed from the race schedule"
+		messages = cancellationMessages(config, signedUp)
+	}
+
+	if err := db.Delete(race).Error; err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+	log.Printf("Removed %s on %s as it is no longer in the race schedule\n", race.Name, race.Date)
+	return messages
+}
+
+// Returns the name of the role matching a schedule column header. Columns for unknown
+// roles are ignored, with a warning logged the first time each column is seen.
+func (config ProgramConfig) scheduleRole(header string, column string, ignored map[string]bool) (string, bool) {
+	role, exists := config.findRole(header)
+	if !exists {
+		if name := header + " " + column; !ignored[name] {
+			log.Printf("Ignoring the '%v' column in the race schedule - there is no '%v' role\n", name, header)
+			ignored[name] = true
+		}
+		return "", false
+	}
+	return role.Name, true
+}
+
+// Copies the schedule details from the CSV entry onto an existing race, marking the
+// calendar event as outdated if anything changed. Capacities are only updated for the
+// roles with a value in the CSV, and never replace a capacity set with the capacity command.
+// Minimums always match the CSV. Returns the emails for the users promoted from the waitlist.
+func updateRaceDetails(db *gorm.DB, config ProgramConfig, race *Race, entry *Race) []EmailMessage {
+	messages := []EmailMessage{}
+	changed := race.StartTime != entry.StartTime ||
+		race.DurationMinutes != entry.DurationMinutes ||
+		race.Location != entry.Location ||
+		race.Description != entry.Description
+
+	race.StartTime = entry.StartTime
+	race.DurationMinutes = entry.DurationMinutes
+	race.Location = entry.Location
+	race.Description = entry.Description
+
+	for _, capacity := range entry.Capacities {
+		if existing := race.capacityFor(capacity.Role); existing == nil || (existing.Limit != capacity.Limit && existing.Source != capacitySourceAdmin) {
+			messages = append(messages, setRaceCapacity(db, config, race, capacity.Role, capacity.Limit, capacitySourceSchedule)...)
+		}
+	}
+
+	if !sameMinimums(race.Minimums, entry.Minimums) {
+		db.Where("race_id = ?", race.ID).Delete(&RaceMinimum{})
+		race.Minimums = []*RaceMinimum{}
+		for _, minimum := range entry.Minimums {
+			minimum.RaceID = race.ID
+			db.Create(minimum)
+			race.Minimums = append(race.Minimums, minimum)
+		}
+		log.Printf("Updated signup minimums for %s\n", race.Name)
+	}
+
+	if changed {
+		log.Printf("Updated schedule details for %s\n", race.Name)
+		race.CalendarOutdated = true
+		db.Omit("Capacities", "Minimums").Save(race)
+	}
+	return messages
+}
+
+func sameMinimums(a []*RaceMinimum, b []*RaceMinimum) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, minimum := range b {
+		if (Race{Minimums: a}).minimumFor(minimum.Role, -1) != minimum.Minimum {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestScheduleIgnoresUnknownRoleColumns(t *testing.T) {
+	progConfig := ProgramConfig{
+		DataFolder: t.TempDir(),
+		Roles:      []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 4}},
+	}
+	db := openDatabaseFile(filepath.Join(progConfig.DataFolder, "db.sqlite"))
+
+	contents := "Name,Date,RC Capacity,Crew Capacity,Crew Minimum\n" +
+		"Race 1,2030-06-01,3,5,2\n"
+	if err := os.WriteFile(progConfig.racesFile(), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileRaceSchedule(db, progConfig, newMemoryCalendarStore())
+
+	race := &Race{}
+	if err := db.Preload("Capacities").Preload("Minimums").First(race).Error; err != nil {
+		t.Fatalf("expected the race to be added: %v", err)
+	}
+	if len(race.Capacities) != 1 || race.Capacities[0].Role != "RC" || race.Capacities[0].Limit != 3 {
+		t.Errorf("expected only the RC capacity, got %v", race.Capacities)
+	}
+	if len(race.Minimums) != 0 {
+		t.Errorf("expected the unknown minimum to be ignored, got %v", race.Minimums)
+	}
+}
+
+func TestScheduleRenamesRace(t *testing.T) {
+	test := newSyncTest(t, 2)
+	test.writeSchedule(map[string]int{"Race 1": 7})
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+	test.sync()
+	eventID := *test.race("Race 1").EventID
+
+	test.writeSchedule(map[string]int{"Regatta": 7})
+	test.sync()
+
+	race := test.race("Regatta")
+	if signedUp, _ := signupEmails(race); fmt.Sprint(signedUp) != "[a@example.org]" {
+		t.Errorf("expected the signup to carry over to the renamed race, got %v", signedUp)
+	}
+	if *race.EventID != eventID {
+		t.Errorf("expected the calendar event to be kept, got %v instead of %v", *race.EventID, eventID)
+	}
+	if summary := test.calendar.Events[eventID].Summary; !strings.Contains(summary, "Regatta") {
+		t.Errorf("expected the calendar event to be renamed, got %q", summary)
+	}
+}
+
+func TestScheduleRemovesRace(t *testing.T) {
+	for _, noNotify := range []bool{false, true} {
+		test := newSyncTest(t, 2)
+		test.calendar.NoNotify = noNotify
+		test.writeSchedule(map[string]int{"Race 1": 7, "Race 2": 14})
+		test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+		test.sync()
+		eventID := *test.race("Race 1").EventID
+		sent := len(test.sentTo("a@example.org"))
+
+		test.writeSchedule(map[string]int{"Race 2": 14})
+		test.sync()
+
+		if _, err := test.progConfig.formConfigs(nil)[0].findRace(test.db, "Race 1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("expected the race to be removed, got %v", err)
+		}
+		if _, exists := test.calendar.Events[eventID]; exists {
+			t.Errorf("expected the calendar event to be deleted")
+		}
+
+		emails := test.sentTo("a@example.org")[sent:]
+		if noNotify {
+			if len(emails) != 1 || !strings.Contains(emails[0], "cancelled") {
+				t.Errorf("expected a cancellation email when the calendar cannot notify attendees, got %v", emails)
+			}
+		} else if fmt.Sprint(test.calendar.Notified) != fmt.Sprint([]string{eventID}) || len(emails) != 0 {
+			t.Errorf("expected the calendar to notify the signups, got %v and emails %v", test.calendar.Notified, emails)
+		}
+	}
+}
+
+func TestScheduleKeepsRacesWhenRowsAreSkipped(t *testing.T) {
+	test := newSyncTest(t, 2)
+	test.writeSchedule(map[string]int{"Race 1": 7, "Race 2": 14})
+	test.sync()
+
+	schedule := fmt.Sprintf("name,date,start\nRace 2,%v,18:00\nRace 1,next week,18:00\n", time.Now().AddDate(0, 0, 14).Format(time.DateOnly))
+	if err := os.WriteFile(test.progConfig.racesFile(), []byte(schedule), 0644); err != nil {
+		t.Fatal(err)
+	}
+	test.sync()
+
+	if race := test.race("Race 1"); race.EventID == nil || test.calendar.Events[*race.EventID] == nil {
+		t.Errorf("expected the race and its calendar event to be kept while a row is skipped")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+// rosterServer hosts the public signup roster and web signup form for upcoming races
+type rosterServer struct {
+	progConfig  ProgramConfig
+	db          *gorm.DB
+	templates   *template.Template
+	membership  MembershipSource
+	notifier    Notifier
+	membersLock sync.RWMutex
+	members     []UserEntry
+}
+
+// rosterRole describes one of the signup roles shown for each race
+type rosterRole struct {
+	Label      string
+	Role       string
+	Limit      int
+	FormURL    string
+	Names      []string
+	Count      int
+	Remaining  int
+	Waitlisted int
+}
+
+type rosterRace struct {
+	ID        uint
+	Name      string
+	Date      string
+	Cancelled bool
+	Roles     []rosterRole
+}
+
+func newRosterServer(progConfig ProgramConfig, db *gorm.DB, membership MembershipSource, notifier Notifier) *rosterServer {
+	templates, err := template.New("").Funcs(template.FuncMap{"join": strings.Join}).ParseFS(templateFiles, "templates/*.html")
+	if err != nil {
+		log.Fatalf("Unable to parse templates: %v", err)
+	}
+
+	return &rosterServer{
+		progConfig: progConfig,
+		db:         db,
+		templates:  templates,
+		membership: membership,
+		notifier:   notifier,
+		members:    []UserEntry{},
+	}
+}
+
+func runServeCommand(progConfig ProgramConfig, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	refresh := flags.Duration("refresh", time.Hour, "how often to reload the membership list")
+	flags.Parse(args)
+
+	server := newRosterServer(progConfig, progConfig.openDatabase(), progConfig.openMembershipSource(), progConfig.newNotifier())
+	if err := server.loadMembers(); err != nil {
+		log.Fatalf("Unable to read membership list: %v", err)
+	}
+	go server.refreshMembers(*refresh)
+
+	log.Printf("Serving signup roster on %v\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server.routes()))
+}
+
+func (server *rosterServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", server.handleRoster)
+	mux.HandleFunc("GET /signup", server.handleSignupForm)
+	mux.HandleFunc("POST /signup", server.handleSignupSubmit)
+	mux.HandleFunc("GET /signup/confirm", server.handleSignupConfirmForm)
+	mux.HandleFunc("POST /signup/confirm", server.handleSignupConfirm)
+	mux.HandleFunc("GET /calendar.ics", server.handleClubFeed)
+	mux.HandleFunc("GET /calendar/{file}", server.handleUserFeed)
+	return mux
+}
+
+// Returns the public link to a Google Form, or an empty string if no form is configured
+func formURL(formCode string) string {
+	if len(formCode) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://docs.google.com/forms/d/%s/viewform", formCode)
+}
+
+// Returns the first name only, so that the public roster does not show full names
+func firstName(name string) string {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return "Member"
+	}
+	return fields[0]
+}
+
+// Returns the configured roles, linking each to the first form bound to the role
+func (server *rosterServer) roles() []rosterRole {
+	roles := []rosterRole{}
+	for _, role := range server.progConfig.roles() {
+		formConfig, _ := server.progConfig.formConfigForRole(role.Name, nil)
+		roles = append(roles, rosterRole{Label: role.label(), Role: role.Name, Limit: role.EntryLimit, FormURL: formURL(formConfig.FormCode)})
+	}
+	return roles
+}
+
+// Returns the races on or after today, in date order, with the roster for each role
+func (server *rosterServer) upcomingRaces() []rosterRace {
+	today := time.Now().In(server.progConfig.timezone()).Format(time.DateOnly)
+
+	races := []*Race{}
+	result := preloadSignups(preloadRaceDetails(server.db)).Where("date >= ?", today).Order("date").Order("name").Find(&races)
+	if result.Error != nil {
+		log.Printf("Error getting database races: %v", result.Error)
+		return []rosterRace{}
+	}
+
+	upcoming := []rosterRace{}
+	for _, race := range races {
+		entry := rosterRace{ID: race.ID, Name: race.Name, Date: race.Date, Cancelled: race.Cancelled, Roles: []rosterRole{}}
+
+		for _, role := range server.roles() {
+			users := race.usersFor(role.Role)
+
+			role.Names = []string{}
+			for _, u := range users {
+				role.Names = append(role.Names, firstName(u.Name))
+			}
+
+			role.Count = len(users)
+			role.Waitlisted = len(race.waitlistFor(role.Role))
+			role.Remaining = -1
+			if limit := race.entryLimit(role.Role, role.Limit); limit >= 0 {
+				role.Remaining = max(limit-role.Count, 0)
+			}
+
+			entry.Roles = append(entry.Roles, role)
+		}
+
+		upcoming = append(upcoming, entry)
+	}
+
+	return upcoming
+}
+
+func (server *rosterServer) handleRoster(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Races []rosterRace
+		Roles []rosterRole
+	}{
+		Races: server.upcomingRaces(),
+		Roles: server.roles(),
+	}
+
+	if err := server.templates.ExecuteTemplate(w, "roster.html", data); err != nil {
+		log.Printf("Unable to render roster: %v", err)
+	}
+}
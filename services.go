@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/forms/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// FormStore provides access to the signup forms, their responses, and the race options
+type FormStore interface {
+	GetForm(formCode string) (*forms.Form, error)
+	ListResponses(formCode string) ([]*forms.FormResponse, error)
+	UpdateItem(formCode string, item *forms.Item, index int64) error
+}
+
+// CalendarStore manages the calendar events linked to each race
+type CalendarStore interface {
+	GetEvent(eventID string) (*calendar.Event, error)
+	InsertEvent(event *calendar.Event) (*calendar.Event, error)
+	UpdateEvent(eventID string, event *calendar.Event, notify bool) (*calendar.Event, error)
+	DeleteEvent(eventID string) error
+	NotifiesAttendees() bool
+}
+
+// MembershipSource provides the raw rows of the membership list
+type MembershipSource interface {
+	MembershipRows() ([][]string, error)
+}
+
+// SyncServices groups the external services used during a sync
+type SyncServices struct {
+	Forms      FormStore
+	Calendar   CalendarStore
+	Membership MembershipSource
+	Notifier   Notifier
+	Webhook    Notifier
+	Plan       *SyncPlan
+}
+
+func newGoogleServices(progConfig ProgramConfig) SyncServices {
+	ctx, client := getGoogleContext(progConfig)
+
+	return SyncServices{
+		Forms:      newGoogleFormStore(ctx, client),
+		Calendar:   progConfig.newCalendarStore(ctx, client),
+		Membership: progConfig.newMembershipSource(ctx, client),
+		Notifier:   progConfig.newNotifier(),
+		Webhook:    progConfig.newWebhook(),
+	}
+}
+
+type googleFormStore struct {
+	ctx context.Context
+	srv *forms.Service
+}
+
+func newGoogleFormStore(ctx context.Context, client *http.Client) *googleFormStore {
+	srv, err := forms.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		log.Fatalf("Unable to retrieve Form client: %v", err)
+	}
+	return &googleFormStore{ctx: ctx, srv: srv}
+}
+
+func (store *googleFormStore) GetForm(formCode string) (*forms.Form, error) {
+	return store.srv.Forms.Get(formCode).Do()
+}
+
+func (store *googleFormStore) ListResponses(formCode string) ([]*forms.FormResponse, error) {
+	responses := []*forms.FormResponse{}
+	err := store.srv.Forms.Responses.List(formCode).Pages(store.ctx, func(page *forms.ListFormResponsesResponse) error {
+		responses = append(responses, page.Responses...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+func (store *googleFormStore) UpdateItem(formCode string, item *forms.Item, index int64) error {
+	_, err := store.srv.Forms.BatchUpdate(formCode, &forms.BatchUpdateFormRequest{
+		IncludeFormInResponse: false,
+		Requests: []*forms.Request{
+			{
+				UpdateItem: &forms.UpdateItemRequest{
+					Item:       item,
+					UpdateMask: "questionItem",
+					Location:   &forms.Location{Index: index},
+				},
+			},
+		},
+	}).Do()
+	return err
+}
+
+// Returns the calendar store for the configured calendar backend, which is Google Calendar by default
+func (config ProgramConfig) newCalendarStore(ctx context.Context, client *http.Client) CalendarStore {
+	switch config.CalendarBackend {
+	case "", "google":
+		return newGoogleCalendarStore(ctx, client, config.CalendarCode)
+	case "caldav":
+		return newCalDAVCalendarStore(config.CalDAV)
+	default:
+		log.Fatalf("Unknown calendar backend '%v'", config.CalendarBackend)
+		return nil
+	}
+}
+
+type googleCalendarStore struct {
+	srv          *calendar.Service
+	calendarCode string
+}
+
+func newGoogleCalendarStore(ctx context.Context, client *http.Client, calendarCode string) *googleCalendarStore {
+	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+	}
+	return &googleCalendarStore{srv: srv, calendarCode: calendarCode}
+}
+
+func (store *googleCalendarStore) GetEvent(eventID string) (*calendar.Event, error) {
+	return store.srv.Events.Get(store.calendarCode, eventID).Do()
+}
+
+func (store *googleCalendarStore) InsertEvent(event *calendar.Event) (*calendar.Event, error) {
+	return store.srv.Events.Insert(store.calendarCode, event).Do()
+}
+
+func (store *googleCalendarStore) UpdateEvent(eventID string, event *calendar.Event, notify bool) (*calendar.Event, error) {
+	call := store.srv.Events.Update(store.calendarCode, eventID, event)
+	if notify {
+		call = call.SendUpdates("all")
+	}
+	return call.Do()
+}
+
+func (store *googleCalendarStore) DeleteEvent(eventID string) error {
+	return store.srv.Events.Delete(store.calendarCode, eventID).SendUpdates("all").Do()
+}
+
+func (store *googleCalendarStore) NotifiesAttendees() bool {
+	return true
+}
+
+type googleSheetSource struct {
+	srv     *sheets.Service
+	sheetID string
+}
+
+func newGoogleSheetSource(ctx context.Context, client *http.Client, sheetID string) *googleSheetSource {
+	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		log.Fatalf("Unable to retrieve Sheets client: %v", err)
+	}
+	return &googleSheetSource{srv: srv, sheetID: sheetID}
+}
+
+func (source *googleSheetSource) MembershipRows() ([][]string, error) {
+	// Read every column so that the membership columns may be found by header
+	readRange := "A:ZZ"
+	resp, err := source.srv.Spreadsheets.Values.Get(source.sheetID, readRange).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{}
+	for _, row := range resp.Values {
+		cells := []string{}
+		for _, cell := range row {
+			cells = append(cells, fmt.Sprint(cell))
+		}
+		rows = append(rows, cells)
+	}
+
+	return rows, nil
+}
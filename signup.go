@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	actionSignup    = "signup"
+	actionCancel    = "cancel"
+	actionPromotion = "promotion"
+)
+
+const (
+	outcomeAccepted              = "accepted"
+	outcomeWaitlisted            = "waitlisted"
+	outcomeCancelled             = "cancelled"
+	outcomePromoted              = "promoted"
+	outcomeRejectedNotMember     = "rejected-not-member"
+	outcomeRejectedMembership    = "rejected-membership"
+	outcomeRejectedUnknownRace   = "rejected-unknown-race"
+	outcomeRejectedUnknownAction = "rejected-unknown-action"
+	outcomeRejectedClosed        = "rejected-closed"
+	outcomeRejectedCancelled     = "rejected-cancelled"
+	outcomeRejectedQuota         = "rejected-quota"
+	outcomeRejectedDuties        = "rejected-duties"
+)
+
+func isRejected(outcome string) bool {
+	return strings.HasPrefix(outcome, "rejected")
+}
+
+// SignupResult describes the effect of a signup or cancel action on a race
+type SignupResult struct {
+	Outcome  string
+	Promoted []*User
+}
+
+// Loads the race by name, including the signups and the waitlist
+func (config FormConfig) findRace(db *gorm.DB, raceName string) (*Race, error) {
+	return config.findRaceOn(db, raceName, "")
+}
+
+// Loads the race by name and date, including the signups and the waitlist. The first
+// race with the name is loaded when the date is empty.
+func (config FormConfig) findRaceOn(db *gorm.DB, raceName string, date string) (*Race, error) {
+	targetRace := &Race{
+		Name: raceName,
+		Date: date,
+	}
+
+	err := preloadSignups(preloadRaceDetails(db)).Where(targetRace).First(targetRace).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return targetRace, nil
+}
+
+// Loads the race by ID, including the signups and the waitlist, for races whose name
+// may be shared with other races
+func loadRace(db *gorm.DB, raceID uint) (*Race, error) {
+	race := &Race{}
+	if err := preloadSignups(preloadRaceDetails(db)).First(race, raceID).Error; err != nil {
+		return nil, err
+	}
+	return race, nil
+}
+
+// Adds the capacities and the waitlist, in queue order, to the races loaded by the query
+func preloadRaceDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("Capacities").Preload("Waitlist", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Waitlist.User")
+}
+
+// Returns true if the race is offered for signups through the form at the given time
+func (config FormConfig) isRaceOpen(race *Race, currentTime time.Time, loc *time.Location) bool {
+	if race.Cancelled {
+		return false
+	}
+
+	raceTime := race.Time(loc)
+	validRaceTime := raceTime.After(currentTime)
+
+	if config.ShowEntryTimeLimit != nil && validRaceTime {
+		validRaceTime = currentTime.After(raceTime.Add(-*config.ShowEntryTimeLimit))
+	}
+
+	return validRaceTime
+}
+
+// Returns the entry limit for the race, using the race capacity for the form's role if one is set
+func (config FormConfig) entryLimit(race *Race) int {
+	return race.entryLimit(config.Role, config.EntryLimit)
+}
+
+func (config FormConfig) hasSpace(race *Race, count int) bool {
+	limit := config.entryLimit(race)
+	return limit < 0 || count < limit
+}
+
+// Returns the waitlist entries for the form's role in queue order
+func (config FormConfig) getWaitlist(race *Race) []*WaitlistEntry {
+	return race.waitlistFor(config.Role)
+}
+
+// Checks that the user may perform the action before applying it to a race loaded with findRace.
+// The roster of a cancelled race is kept as it was when the race was cancelled, and signups
+// for a race with an undrawn lottery are entered in the lottery.
+func (config FormConfig) processAction(db *gorm.DB, race *Race, user *User, action string) (SignupResult, error) {
+	rejected := func(outcome string) (SignupResult, error) {
+		return SignupResult{Outcome: outcome, Promoted: []*User{}}, nil
+	}
+
+	if allowed, outcome := config.canPerformActionForUser(user, race, action); !allowed {
+		return rejected(outcome)
+	} else if action != actionSignup && action != actionCancel {
+		return rejected(outcomeRejectedUnknownAction)
+	} else if race.Cancelled {
+		return rejected(outcomeRejectedCancelled)
+	}
+
+	if action == actionSignup {
+		currentTime := config.now()
+		checks := []struct {
+			outcome string
+			check   func() (bool, error)
+		}{
+			{outcomeRejectedQuota, func() (bool, error) { return config.overQuota(db, race, user, currentTime) }},
+			{outcomeRejectedDuties, func() (bool, error) { return config.owesDuties(db, race, user, currentTime) }},
+			{outcomeRejectedCredits, func() (bool, error) { return config.lacksCredits(db, race, user, currentTime) }},
+		}
+		for _, c := range checks {
+			if failed, err := c.check(); err != nil {
+				return SignupResult{}, err
+			} else if failed {
+				return rejected(c.outcome)
+			}
+		}
+	}
+
+	if pending, err := config.lotteryPending(db, race); err != nil {
+		return SignupResult{}, err
+	} else if pending {
+		return config.applyLotteryRequest(db, race, user, action)
+	}
+
+	return config.applyAction(db, race, user, action)
+}
+
+// Applies a signup or cancel action for the user on a race loaded with findRace.
+// Signups beyond the entry limit are added to the waitlist, and cancellations
+// promote users from the front of the waitlist into the open spaces.
+func (config FormConfig) applyAction(db *gorm.DB, race *Race, user *User, action string) (SignupResult, error) {
+	result := SignupResult{Promoted: []*User{}}
+
+	listWithoutUser := []*User{}
+	for _, u := range config.getUsers(race) {
+		if u.ID != user.ID {
+			listWithoutUser = append(listWithoutUser, u)
+		}
+	}
+
+	waitlist := config.getWaitlist(race)
+	var userEntry *WaitlistEntry = nil
+	for _, entry := range waitlist {
+		if entry.UserID == user.ID {
+			userEntry = entry
+		}
+	}
+
+	if action == actionSignup {
+		if config.hasSpace(race, len(listWithoutUser)) {
+			result.Outcome = outcomeAccepted
+			listWithoutUser = append(listWithoutUser, user)
+			if userEntry != nil {
+				if err := removeWaitlistEntry(db, race, userEntry); err != nil {
+					return result, err
+				}
+			}
+		} else {
+			result.Outcome = outcomeWaitlisted
+			if userEntry == nil {
+				userEntry = &WaitlistEntry{RaceID: race.ID, UserID: user.ID, User: user, Role: config.Role}
+				if err := db.Omit("User").Create(userEntry).Error; err != nil {
+					return result, err
+				}
+				race.Waitlist = append(race.Waitlist, userEntry)
+			}
+		}
+	} else if action == actionCancel {
+		result.Outcome = outcomeCancelled
+		if userEntry != nil {
+			if err := removeWaitlistEntry(db, race, userEntry); err != nil {
+				return result, err
+			}
+		}
+
+		var err error
+		listWithoutUser, result.Promoted, err = config.fillFromWaitlist(db, race, listWithoutUser)
+		if err != nil {
+			return result, err
+		}
+	} else {
+		return result, fmt.Errorf("unknown action %v", action)
+	}
+
+	return result, config.saveUserTable(db, race, listWithoutUser)
+}
+
+// Promotes users from the front of the waitlist into any open spaces on a race
+// loaded with findRace, such as after the race capacity is increased
+func (config FormConfig) promoteWaitlist(db *gorm.DB, race *Race) ([]*User, error) {
+	userList, promoted, err := config.fillFromWaitlist(db, race, config.getUsers(race))
+	if err != nil {
+		return nil, err
+	} else if len(promoted) > 0 {
+		if err := config.saveUserTable(db, race, userList); err != nil {
+			return nil, err
+		}
+	}
+	return promoted, nil
+}
+
+// Moves users from the front of the waitlist onto the user list while there is space,
+// returning the new user list and the promoted users
+func (config FormConfig) fillFromWaitlist(db *gorm.DB, race *Race, userList []*User) ([]*User, []*User, error) {
+	promoted := []*User{}
+	for _, entry := range config.getWaitlist(race) {
+		if !config.hasSpace(race, len(userList)) {
+			break
+		}
+		userList = append(userList, entry.User)
+		promoted = append(promoted, entry.User)
+		if err := removeWaitlistEntry(db, race, entry); err != nil {
+			return nil, nil, err
+		}
+		log.Printf("%s promoted from the %s waitlist for %s\n", entry.User.Email, config.Role, race.Name)
+	}
+	return userList, promoted, nil
+}
+
+// Saves the user list for the form's role, assigning boats to new signups if the role uses boats
+func (config FormConfig) saveUserTable(db *gorm.DB, race *Race, userList []*User) error {
+	if err := race.saveUsersFor(db, config.Role, userList); err != nil {
+		return err
+	} else if !config.Boats {
+		return nil
+	}
+
+	boats, err := activeBoats(db)
+	if err != nil {
+		return err
+	}
+	_, err = race.assignBoats(db, config.Role, boats)
+	return err
+}
+
+func removeWaitlistEntry(db *gorm.DB, race *Race, entry *WaitlistEntry) error {
+	if err := db.Delete(entry).Error; err != nil {
+		return err
+	}
+	remaining := []*WaitlistEntry{}
+	for _, e := range race.Waitlist {
+		if e.ID != entry.ID {
+			remaining = append(remaining, e)
+		}
+	}
+	race.Waitlist = remaining
+	return nil
+}
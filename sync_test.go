@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const testFormCode = "rc-form"
+
+// syncTest runs full syncs against the in-memory services with a single RC form
+type syncTest struct {
+	t          *testing.T
+	progConfig ProgramConfig
+	db         *gorm.DB
+	services   SyncServices
+	forms      *memoryFormStore
+	calendar   *memoryCalendarStore
+	notifier   *memoryNotifier
+}
+
+func newSyncTest(t *testing.T, entryLimit int) *syncTest {
+	t.Helper()
+
+	progConfig := ProgramConfig{
+		DataFolder: t.TempDir(),
+		Roles:      []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: entryLimit}},
+		Forms:      []ProgramConfigForm{{FormCode: testFormCode, Role: "RC"}},
+	}
+	services := newMemoryServices()
+	services.Membership = &memoryMembershipSource{Rows: [][]string{
+		{"a@example.org", "A", currentYear()},
+		{"b@example.org", "B", currentYear()},
+	}}
+
+	test := &syncTest{
+		t:          t,
+		progConfig: progConfig,
+		db:         openDatabaseFile(filepath.Join(progConfig.DataFolder, "db.sqlite")),
+		services:   services,
+		forms:      services.Forms.(*memoryFormStore),
+		calendar:   services.Calendar.(*memoryCalendarStore),
+		notifier:   services.Notifier.(*memoryNotifier),
+	}
+	test.forms.addSignupForm(testFormCode, "RC")
+	return test
+}
+
+// Writes the race schedule with each race name on the date the given number of days from now
+func (test *syncTest) writeSchedule(days map[string]int) {
+	test.t.Helper()
+
+	lines := []string{"name,date,start"}
+	for name, offset := range days {
+		lines = append(lines, fmt.Sprintf("%v,%v,18:00", name, time.Now().AddDate(0, 0, offset).Format(time.DateOnly)))
+	}
+	if err := os.WriteFile(test.progConfig.racesFile(), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		test.t.Fatal(err)
+	}
+}
+
+func (test *syncTest) sync() {
+	runSync(test.progConfig, test.db, test.services, false)
+}
+
+func (test *syncTest) race(name string) *Race {
+	test.t.Helper()
+
+	race, err := test.progConfig.formConfigs(nil)[0].findRace(test.db, name)
+	if err != nil {
+		test.t.Fatalf("loading %v: %v", name, err)
+	}
+	return race
+}
+
+// Returns the emails of the users in the role, or on the waitlist of the role, in order
+func signupEmails(race *Race) (signedUp []string, waitlisted []string) {
+	for _, user := range race.usersFor("RC") {
+		signedUp = append(signedUp, user.Email)
+	}
+	for _, entry := range race.waitlistFor("RC") {
+		waitlisted = append(waitlisted, entry.User.Email)
+	}
+	return signedUp, waitlisted
+}
+
+// Returns the subjects of the emails sent to the address
+func (test *syncTest) sentTo(email string) []string {
+	subjects := []string{}
+	for _, message := range test.notifier.Sent {
+		if message.To == email {
+			subjects = append(subjects, message.Subject)
+		}
+	}
+	return subjects
+}
+
+func (test *syncTest) attendees(race *Race) []string {
+	test.t.Helper()
+
+	if race.EventID == nil {
+		test.t.Fatalf("no calendar event for %v", race.Name)
+	}
+	emails := []string{}
+	for _, attendee := range test.calendar.Events[*race.EventID].Attendees {
+		emails = append(emails, attendee.Email)
+	}
+	return emails
+}
+
+func TestSyncSignupWaitlistAndPromotion(t *testing.T) {
+	test := newSyncTest(t, 1)
+	test.writeSchedule(map[string]int{"Race 1": 7})
+
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+	test.forms.addResponse(testFormCode, "b@example.org", "B", "Signup", "Race 1")
+	test.forms.addResponse(testFormCode, "stranger@example.org", "Stranger", "Signup", "Race 1")
+	test.sync()
+
+	race := test.race("Race 1")
+	if signedUp, waitlisted := signupEmails(race); fmt.Sprint(signedUp, waitlisted) != "[a@example.org] [b@example.org]" {
+		t.Fatalf("expected a signed up and b waitlisted, got %v and %v", signedUp, waitlisted)
+	}
+	if attendees := test.attendees(race); fmt.Sprint(attendees) != "[a@example.org]" {
+		t.Errorf("expected a on the calendar event, got %v", attendees)
+	}
+	if sent := test.sentTo("b@example.org"); len(sent) != 1 || !strings.HasPrefix(sent[0], "Waitlisted") {
+		t.Errorf("expected a waitlist email for b, got %v", sent)
+	}
+	if sent := test.sentTo("stranger@example.org"); len(sent) != 1 {
+		t.Errorf("expected a rejection email for a non-member, got %v", sent)
+	}
+
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Cancel", "Race 1")
+	test.sync()
+
+	race = test.race("Race 1")
+	if signedUp, waitlisted := signupEmails(race); fmt.Sprint(signedUp, waitlisted) != "[b@example.org] []" {
+		t.Fatalf("expected b promoted, got %v and %v", signedUp, waitlisted)
+	}
+	if attendees := test.attendees(race); fmt.Sprint(attendees) != "[b@example.org]" {
+		t.Errorf("expected b on the calendar event, got %v", attendees)
+	}
+	if sent := test.sentTo("b@example.org"); len(sent) != 2 {
+		t.Errorf("expected a promotion email for b, got %v", sent)
+	}
+}
+
+func TestSyncReprocessesEditedResponse(t *testing.T) {
+	test := newSyncTest(t, 2)
+	test.writeSchedule(map[string]int{"Race 1": 7})
+
+	response := test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+	test.sync()
+	test.sync()
+
+	var events int64
+	test.db.Model(&SignupEvent{}).Count(&events)
+	if events != 1 {
+		t.Fatalf("expected an unchanged response to be applied once, got %v events", events)
+	}
+
+	// Editing the response changes its last submitted time, so it is applied again
+	response.Answers["action"].TextAnswers.Answers[0].Value = "Cancel"
+	response.LastSubmittedTime = time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano)
+	test.sync()
+
+	if signedUp, _ := signupEmails(test.race("Race 1")); len(signedUp) != 0 {
+		t.Errorf("expected the edited response to cancel the signup, got %v", signedUp)
+	}
+	test.db.Model(&SignupEvent{}).Count(&events)
+	if events != 2 {
+		t.Errorf("expected the edited response to be recorded, got %v events", events)
+	}
+}
+
+func TestSyncMovesRace(t *testing.T) {
+	test := newSyncTest(t, 2)
+	test.writeSchedule(map[string]int{"Race 1": 7})
+
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+	test.sync()
+	eventID := *test.race("Race 1").EventID
+
+	test.writeSchedule(map[string]int{"Race 1": 14})
+	test.sync()
+
+	race := test.race("Race 1")
+	if newDate := time.Now().AddDate(0, 0, 14).Format(time.DateOnly); race.Date != newDate {
+		t.Fatalf("expected the race to move to %v, got %v", newDate, race.Date)
+	}
+	if signedUp, _ := signupEmails(race); fmt.Sprint(signedUp) != "[a@example.org]" {
+		t.Errorf("expected the signup to move with the race, got %v", signedUp)
+	}
+	if *race.EventID != eventID {
+		t.Errorf("expected the calendar event to be kept, got %v instead of %v", *race.EventID, eventID)
+	}
+	if start := test.calendar.Events[eventID].Start.DateTime; !strings.HasPrefix(start, race.Date) {
+		t.Errorf("expected the calendar event to start on %v, got %v", race.Date, start)
+	}
+}
+
+func TestSyncLottery(t *testing.T) {
+	test := newSyncTest(t, 1)
+	test.progConfig.Forms[0].LotteryDrawHours = 1
+	test.writeSchedule(map[string]int{"Race 1": 7})
+
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+	test.forms.addResponse(testFormCode, "b@example.org", "B", "Signup", "Race 1")
+	test.sync()
+
+	if signedUp, waitlisted := signupEmails(test.race("Race 1")); len(signedUp) != 0 || len(waitlisted) != 0 {
+		t.Fatalf("expected lottery requests only before the draw, got %v and %v", signedUp, waitlisted)
+	}
+	for _, email := range []string{"a@example.org", "b@example.org"} {
+		if sent := test.sentTo(email); len(sent) != 1 || !strings.HasPrefix(sent[0], "Entered") {
+			t.Errorf("expected a lottery entry email for %v, got %v", email, sent)
+		}
+	}
+
+	// Moving the draw time before now draws the lottery on the next sync
+	test.progConfig.Forms[0].LotteryDrawHours = 24 * 30
+	test.sync()
+
+	signedUp, waitlisted := signupEmails(test.race("Race 1"))
+	if len(signedUp) != 1 || len(waitlisted) != 1 {
+		t.Fatalf("expected one drawn signup and one waitlisted, got %v and %v", signedUp, waitlisted)
+	}
+	if sent := test.sentTo(signedUp[0]); len(sent) != 2 || !strings.HasPrefix(sent[1], "You won") {
+		t.Errorf("expected a lottery win email for %v, got %v", signedUp[0], sent)
+	}
+	if sent := test.sentTo(waitlisted[0]); len(sent) != 2 || !strings.HasPrefix(sent[1], "Waitlisted") {
+		t.Errorf("expected a lottery waitlist email for %v, got %v", waitlisted[0], sent)
+	}
+}
+
+func TestOfflineServicesLoadResponsesAndMembers(t *testing.T) {
+	progConfig := ProgramConfig{
+		DataFolder: t.TempDir(),
+		Roles:      []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 1}},
+		Forms:      []ProgramConfigForm{{FormCode: testFormCode, Role: "RC"}},
+	}
+	files := map[string]string{
+		progConfig.offlineResponsesFile(): "form,email,name,action,races\n" + testFormCode + ",a@example.org,A,Signup,Race 1;Race 2\n",
+		progConfig.offlineMembersFile():   "Email,Name,Year\na@example.org,A," + currentYear() + "\n",
+	}
+	for file, contents := range files {
+		if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	services := newOfflineServices(progConfig)
+
+	responses, err := services.Forms.ListResponses(testFormCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || len(responses[0].Answers["races"].TextAnswers.Answers) != 2 {
+		t.Errorf("expected one response for two races, got %v", responses)
+	}
+
+	members, err := progConfig.getValidSheetEmails(services.Membership)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Email != "a@example.org" {
+		t.Errorf("expected the offline member, got %v", members)
+	}
+}
+
+func TestSyncMatchesRaceOptionDate(t *testing.T) {
+	test := newSyncTest(t, 2)
+	first := time.Now().AddDate(0, 0, 7).Format(time.DateOnly)
+	second := time.Now().AddDate(0, 0, 14).Format(time.DateOnly)
+	schedule := fmt.Sprintf("name,date,start\nRace 1,%v,18:00\nRace 1,%v,18:00\n", first, second)
+	if err := os.WriteFile(test.progConfig.racesFile(), []byte(schedule), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", fmt.Sprintf("Race 1: %v 18:00 (2 Remaining)", second))
+	test.sync()
+
+	for _, race := range getAllRaces(test.db) {
+		signedUp, _ := signupEmails(race)
+		if race.Date == second && len(signedUp) != 1 {
+			t.Errorf("expected a signup for the race on %v, got %v", race.Date, signedUp)
+		} else if race.Date != second && len(signedUp) != 0 {
+			t.Errorf("expected no signup for the race on %v, got %v", race.Date, signedUp)
+		}
+	}
+}
+
+// Applies the form responses and draws the lotteries without updating the calendar
+func (test *syncTest) updateForm() {
+	test.t.Helper()
+
+	members, err := test.progConfig.getValidSheetEmails(test.services.Membership)
+	if err != nil {
+		test.t.Fatal(err)
+	}
+	updateGoogleForm(test.progConfig, test.progConfig.formConfigs(&members)[0], test.db, test.services, nil)
+}
+
+func TestUpdateFormMarksCalendarOutdated(t *testing.T) {
+	test := newSyncTest(t, 1)
+	test.writeSchedule(map[string]int{"Race 1": 7})
+	test.sync()
+
+	test.forms.addResponse(testFormCode, "stranger@example.org", "Stranger", "Signup", "Race 1")
+	test.updateForm()
+	if test.race("Race 1").CalendarOutdated {
+		t.Fatalf("expected a rejected signup to leave the calendar event as it is")
+	}
+
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+	test.updateForm()
+	if !test.race("Race 1").CalendarOutdated {
+		t.Errorf("expected the signup to mark the calendar event outdated")
+	}
+}
+
+func TestLotteryDrawMarksCalendarOutdated(t *testing.T) {
+	test := newSyncTest(t, 1)
+	test.progConfig.Forms[0].LotteryDrawHours = 1
+	test.writeSchedule(map[string]int{"Race 1": 7})
+	test.forms.addResponse(testFormCode, "a@example.org", "A", "Signup", "Race 1")
+	test.sync()
+
+	if test.race("Race 1").CalendarOutdated {
+		t.Fatalf("expected the calendar event to be up to date before the draw")
+	}
+
+	test.progConfig.Forms[0].LotteryDrawHours = 24 * 30
+	test.updateForm()
+	if !test.race("Race 1").CalendarOutdated {
+		t.Errorf("expected the draw to mark the calendar event outdated")
+	}
+}
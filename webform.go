@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// webSignupResult describes the outcome of a web signup for a single race
+type webSignupResult struct {
+	RaceName string
+	Message  string
+	Boat     string
+	Rejected bool
+}
+
+type webSignupPage struct {
+	Email   string
+	Name    string
+	Error   string
+	Notice  string
+	Confirm *webSignupConfirm
+	Roles   []rosterRole
+	Races   []rosterRace
+	Results []webSignupResult
+}
+
+// webSignupConfirm describes a pending web signup on the page that confirms it
+type webSignupConfirm struct {
+	Token  string
+	Role   string
+	Action string
+	Races  []string
+}
+
+// WebSignupRequest is a web signup that waits for the member to confirm it from the link
+// emailed to them, so that only the owner of an email address can change its signups
+type WebSignupRequest struct {
+	gorm.Model
+	Token       string `gorm:"index"`
+	Email       string
+	Name        string
+	Role        string
+	Action      string
+	Races       string // race IDs, one per line
+	ExpiresAt   time.Time
+	ConfirmedAt *time.Time
+}
+
+const (
+	emailWebConfirm = "web-confirm"
+
+	webConfirmTimeout = 24 * time.Hour
+)
+
+var webSignupMessages = map[string]string{
+	outcomeAccepted:              "signed up",
+	outcomeWaitlisted:            "the race is full, you have been added to the waitlist",
+	outcomeCancelled:             "signup cancelled",
+	outcomeRejectedNotMember:     "your email is not on the membership list",
+	outcomeRejectedMembership:    "your membership does not allow signing up for this race",
+	outcomeRejectedUnknownRace:   "race not found",
+	outcomeRejectedUnknownAction: "unknown action",
+	outcomeRejectedClosed:        "signups are not open for this race",
+	outcomeRejectedCancelled:     "this race has been cancelled",
+	outcomeRejectedQuota:         "you have reached the limit of races you may sign up for",
+	outcomeRejectedDuties:        "you have not signed up for the duties you owe this season",
+	outcomeRejectedCredits:       "you do not have enough credits for this race",
+	outcomeLotteryEntered:        "entered in the lottery - you will be emailed the result of the draw",
+}
+
+// Reads the membership list used to gate web signups
+func (server *rosterServer) loadMembers() error {
+	members, err := server.progConfig.getValidSheetEmails(server.membership)
+	if err != nil {
+		return err
+	}
+
+	server.membersLock.Lock()
+	defer server.membersLock.Unlock()
+	server.members = members
+	return nil
+}
+
+// Reloads the membership list periodically so that new members may sign up, keeping
+// the previous list if it cannot be read
+func (server *rosterServer) refreshMembers(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := server.loadMembers(); err != nil {
+			log.Printf("Unable to reload membership list: %v", err)
+		}
+	}
+}
+
+// Returns the form config for each role keyed by role name, gated on the current membership list
+func (server *rosterServer) formConfigs() map[string]FormConfig {
+	server.membersLock.RLock()
+	members := append([]UserEntry{}, server.members...)
+	server.membersLock.RUnlock()
+
+	configs := map[string]FormConfig{}
+	for _, role := range server.progConfig.roles() {
+		if f, exists := server.progConfig.formConfigForRole(role.Name, &members); exists {
+			configs[role.Name] = f
+		}
+	}
+	return configs
+}
+
+func (server *rosterServer) renderSignup(w http.ResponseWriter, page webSignupPage) {
+	page.Roles = server.roles()
+	page.Races = server.upcomingRaces()
+
+	if err := server.templates.ExecuteTemplate(w, "signup.html", page); err != nil {
+		log.Printf("Unable to render signup form: %v", err)
+	}
+}
+
+func (server *rosterServer) handleSignupForm(w http.ResponseWriter, r *http.Request) {
+	server.renderSignup(w, webSignupPage{})
+}
+
+func (server *rosterServer) handleSignupSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	page := webSignupPage{
+		Email: strings.ToLower(strings.TrimSpace(r.PostForm.Get("email"))),
+		Name:  strings.TrimSpace(r.PostForm.Get("name")),
+	}
+	action := r.PostForm.Get("action")
+	raceIDs, err := parseRaceIDs(r.PostForm["race"])
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	formConfig, exists := server.formConfigs()[r.PostForm.Get("role")]
+	if server.notifier == nil || len(server.progConfig.WebURL) == 0 {
+		page.Error = "Web signups are not available. Please use the signup forms."
+	} else if len(page.Email) == 0 || len(page.Name) == 0 {
+		page.Error = "Please enter your email and name."
+	} else if !exists {
+		page.Error = "Please select a role."
+	} else if action != actionSignup && action != actionCancel {
+		page.Error = "Please select an action."
+	} else if len(raceIDs) == 0 {
+		page.Error = "Please select at least one race."
+	}
+
+	if len(page.Error) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		server.renderSignup(w, page)
+		return
+	}
+
+	// Only members are emailed, but the page is the same either way so that it does
+	// not reveal who is on the membership list
+	if server.isMember(page.Email) {
+		if err := server.requestConfirmation(page.Email, page.Name, formConfig, action, raceIDs); err != nil {
+			log.Printf("Unable to request web signup confirmation for %v: %v", page.Email, err)
+			http.Error(w, "unable to send confirmation email", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		log.Printf("Web signup for %v ignored - not on the membership list\n", page.Email)
+	}
+
+	page.Notice = fmt.Sprintf("If %v is on the membership list, a confirmation link has been emailed to it. Your request is applied once you open the link and confirm it.", page.Email)
+	server.renderSignup(w, page)
+}
+
+// Returns true if the email is on the current membership list
+func (server *rosterServer) isMember(email string) bool {
+	server.membersLock.RLock()
+	defer server.membersLock.RUnlock()
+
+	for _, member := range server.members {
+		if strings.EqualFold(member.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the race IDs posted by the signup form or saved with a web signup request
+func parseRaceIDs(values []string) ([]uint, error) {
+	raceIDs := []uint{}
+	for _, value := range values {
+		raceID, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid race ID '%v'", value)
+		}
+		raceIDs = append(raceIDs, uint(raceID))
+	}
+	return raceIDs, nil
+}
+
+// Returns the race IDs of the request
+func (request *WebSignupRequest) raceIDs() ([]uint, error) {
+	return parseRaceIDs(strings.Split(request.Races, "\n"))
+}
+
+// Returns the name and date of each of the races, in date order, for showing the member
+// which races a request is for
+func raceLabels(db *gorm.DB, raceIDs []uint) ([]string, error) {
+	races := []*Race{}
+	if err := db.Where("id IN ?", raceIDs).Order("date").Order("name").Find(&races).Error; err != nil {
+		return nil, err
+	}
+
+	labels := []string{}
+	for _, race := range races {
+		labels = append(labels, fmt.Sprintf("%v: %v", race.Name, race.Date))
+	}
+	return labels, nil
+}
+
+// Returns a random token for a confirmation link
+func newConfirmToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// Saves the web signup as pending and emails the member a link to confirm it
+func (server *rosterServer) requestConfirmation(email string, name string, formConfig FormConfig, action string, raceIDs []uint) error {
+	token, err := newConfirmToken()
+	if err != nil {
+		return err
+	}
+
+	labels, err := raceLabels(server.db, raceIDs)
+	if err != nil {
+		return err
+	}
+
+	races := []string{}
+	for _, raceID := range raceIDs {
+		races = append(races, strconv.FormatUint(uint64(raceID), 10))
+	}
+
+	request := &WebSignupRequest{
+		Token:     token,
+		Email:     email,
+		Name:      name,
+		Role:      formConfig.Role,
+		Action:    action,
+		Races:     strings.Join(races, "\n"),
+		ExpiresAt: time.Now().Add(webConfirmTimeout),
+	}
+	if err := server.db.Create(request).Error; err != nil {
+		return err
+	}
+
+	data := notificationData{
+		Name:   name,
+		Email:  email,
+		Race:   strings.Join(labels, ", "),
+		Role:   formConfig.Label,
+		Action: action,
+		Link:   fmt.Sprintf("%v/signup/confirm?token=%v", strings.TrimSuffix(server.progConfig.WebURL, "/"), token),
+		Others: []string{},
+	}
+	message, exists := server.progConfig.newMessage(emailWebConfirm, data)
+	if !exists {
+		return fmt.Errorf("the %v email template is disabled", emailWebConfirm)
+	}
+	return server.notifier.Send(message)
+}
+
+// Returns the pending web signup with the token that has not expired or been confirmed
+func findWebSignupRequest(db *gorm.DB, token string, currentTime time.Time) (*WebSignupRequest, error) {
+	if len(token) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	request := &WebSignupRequest{}
+	err := db.Where(&WebSignupRequest{Token: token}).
+		Where("confirmed_at IS NULL AND expires_at > ?", currentTime).
+		First(request).Error
+	return request, err
+}
+
+// Shows the pending web signup with a button to confirm it. Signups are only applied from
+// the button so that links opened by mail scanners do not confirm them.
+func (server *rosterServer) handleSignupConfirmForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	request, err := findWebSignupRequest(server.db, token, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		server.renderSignup(w, webSignupPage{Error: "This confirmation link has expired or has already been used."})
+		return
+	} else if err != nil {
+		log.Printf("Unable to find web signup request: %v", err)
+		http.Error(w, "unable to find signup", http.StatusInternalServerError)
+		return
+	}
+
+	label := request.Role
+	if role, exists := server.progConfig.findRole(request.Role); exists {
+		label = role.label()
+	}
+
+	raceIDs, err := request.raceIDs()
+	if err != nil {
+		log.Printf("Unable to read web signup request %v: %v", request.ID, err)
+		http.Error(w, "unable to find signup", http.StatusInternalServerError)
+		return
+	}
+	races, err := raceLabels(server.db, raceIDs)
+	if err != nil {
+		log.Printf("Unable to find web signup races: %v", err)
+		http.Error(w, "unable to find signup", http.StatusInternalServerError)
+		return
+	}
+
+	server.renderSignup(w, webSignupPage{
+		Email: request.Email,
+		Name:  request.Name,
+		Confirm: &webSignupConfirm{
+			Token:  token,
+			Role:   label,
+			Action: request.Action,
+			Races:  races,
+		},
+	})
+}
+
+func (server *rosterServer) handleSignupConfirm(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	page := webSignupPage{}
+	messages := []EmailMessage{}
+	err := server.db.Transaction(func(tx *gorm.DB) error {
+		currentTime := time.Now()
+		request, err := findWebSignupRequest(tx, r.PostForm.Get("token"), currentTime)
+		if err != nil {
+			return err
+		}
+
+		// Mark the request as confirmed first so that it is only ever applied once
+		result := tx.Model(&WebSignupRequest{}).Where("id = ? AND confirmed_at IS NULL", request.ID).Update("ConfirmedAt", currentTime)
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+
+		formConfig, exists := server.formConfigs()[request.Role]
+		if !exists {
+			return fmt.Errorf("unknown role '%v'", request.Role)
+		}
+
+		raceIDs, err := request.raceIDs()
+		if err != nil {
+			return err
+		}
+
+		page.Email, page.Name = request.Email, request.Name
+		page.Results, messages, err = applyWebSignup(tx, server.progConfig, formConfig, request.Email, request.Name, request.Action, raceIDs)
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		server.renderSignup(w, webSignupPage{Error: "This confirmation link has expired or has already been used."})
+		return
+	} else if err != nil {
+		log.Printf("Unable to apply web signup: %v", err)
+		http.Error(w, "unable to save signup", http.StatusInternalServerError)
+		return
+	}
+
+	// Users promoted from the waitlist by a cancellation are emailed once the change is saved
+	sendMessages(server.notifier, messages)
+	server.renderSignup(w, page)
+}
+
+// Applies a signup or cancel action for the races with the IDs from the web form using
+// the same rules as the Google Form sync, marking each changed race so the next sync updates its calendar event.
+// Returns the emails for the users promoted from the waitlist.
+func applyWebSignup(db *gorm.DB, progConfig ProgramConfig, formConfig FormConfig, email string, name string, action string, raceIDs []uint) ([]webSignupResult, []EmailMessage, error) {
+	timestamp := time.Now()
+	responseID := fmt.Sprintf("web-%d", timestamp.UnixNano())
+
+	targetUser := &User{
+		Email: email,
+	}
+	if err := db.Where(targetUser).FirstOrCreate(targetUser).Error; err != nil {
+		return nil, nil, err
+	}
+	targetUser.Name = name
+	if err := db.Save(targetUser).Error; err != nil {
+		return nil, nil, err
+	}
+
+	results := []webSignupResult{}
+	messages := []EmailMessage{}
+	for _, raceID := range raceIDs {
+		outcome := outcomeRejectedUnknownRace
+		promoted := []*User{}
+
+		// Races that no longer exist are recorded by ID
+		raceName := fmt.Sprintf("race %v", raceID)
+		targetRace, err := loadRace(db, raceID)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, err
+			}
+			targetRace = nil
+		} else if raceName = targetRace.Name; targetRace.Cancelled {
+			outcome = outcomeRejectedCancelled
+		} else if action == actionSignup && !formConfig.isRaceOpen(targetRace, timestamp, progConfig.timezone()) {
+			outcome = outcomeRejectedClosed
+		} else if action == actionCancel && !targetRace.Time(progConfig.timezone()).After(timestamp) {
+			outcome = outcomeRejectedClosed
+		} else {
+			result, err := formConfig.processAction(db, targetRace, targetUser, action)
+			if err != nil {
+				return nil, nil, err
+			}
+			outcome, promoted = result.Outcome, result.Promoted
+
+			if !isRejected(outcome) {
+				if err := db.Model(&Race{}).Where("id = ?", targetRace.ID).Update("CalendarOutdated", true).Error; err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+
+		if err := recordSignupEvent(db, formConfig, responseID, timestamp, targetUser, targetRace, raceName, action, outcome); err != nil {
+			return nil, nil, err
+		}
+		for _, promotedUser := range promoted {
+			if err := recordSignupEvent(db, formConfig, responseID, timestamp, promotedUser, targetRace, raceName, actionPromotion, outcomePromoted); err != nil {
+				return nil, nil, err
+			}
+			if message, exists := newSignupMessage(progConfig, formConfig, promotedUser, targetRace, outcomePromoted); exists {
+				messages = append(messages, message)
+			}
+		}
+		log.Printf("%s %s for %s through the web form (%v)\n", targetUser.Email, action, raceName, outcome)
+
+		result := webSignupResult{
+			RaceName: raceName,
+			Message:  webSignupMessages[outcome],
+			Rejected: isRejected(outcome),
+		}
+		if targetRace != nil && outcome == outcomeAccepted {
+			if boat := targetRace.boatFor(formConfig.Role, targetUser.ID); boat != nil {
+				result.Boat = boat.label()
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, messages, nil
+}
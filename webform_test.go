@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func newTestRosterServer(t *testing.T) (*rosterServer, *memoryNotifier) {
+	t.Helper()
+
+	progConfig := ProgramConfig{
+		Roles:      []RoleConfig{{Name: "RC", Label: "RC", EntryLimit: 1}},
+		WebURL:     "https://signup.example.org/",
+		DataFolder: t.TempDir(),
+	}
+	db := openDatabaseFile(filepath.Join(progConfig.DataFolder, "db.sqlite"))
+	notifier := &memoryNotifier{}
+
+	membership := &memoryMembershipSource{Rows: [][]string{
+		{"member@example.org", "Member", currentYear()},
+		{"other@example.org", "Other", currentYear()},
+	}}
+	server := newRosterServer(progConfig, db, membership, notifier)
+	if err := server.loadMembers(); err != nil {
+		t.Fatalf("loading members: %v", err)
+	}
+
+	// The races share a name, as a race held every week might, and are told apart by ID
+	for _, days := range []int{7, 14} {
+		race := &Race{Name: "Race 1", Date: time.Now().AddDate(0, 0, days).Format(time.DateOnly)}
+		if err := db.Create(race).Error; err != nil {
+			t.Fatalf("creating race: %v", err)
+		}
+	}
+	return server, notifier
+}
+
+func currentYear() string {
+	return time.Now().Format("2006")
+}
+
+func postForm(t *testing.T, handler http.Handler, path string, values url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+var confirmLink = regexp.MustCompile(`https://signup\.example\.org/signup/confirm\?token=([0-9a-f]+)`)
+
+func TestWebSignupRequiresConfirmation(t *testing.T) {
+	server, notifier := newTestRosterServer(t)
+	handler := server.routes()
+
+	rec := postForm(t, handler, "/signup", url.Values{
+		"email":  {"member@example.org"},
+		"name":   {"Member"},
+		"role":   {"RC"},
+		"action": {"signup"},
+		"race":   {"2"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("submit returned %v", rec.Code)
+	}
+
+	var signups int64
+	server.db.Model(&RaceSignup{}).Count(&signups)
+	if signups != 0 {
+		t.Fatalf("signup applied before confirmation")
+	}
+
+	if len(notifier.Sent) != 1 || notifier.Sent[0].To != "member@example.org" {
+		t.Fatalf("expected one confirmation email, got %v", notifier.Sent)
+	}
+	match := confirmLink.FindStringSubmatch(notifier.Sent[0].Body)
+	if match == nil {
+		t.Fatalf("no confirmation link in %q", notifier.Sent[0].Body)
+	}
+
+	// Opening the link only shows the confirm button
+	getRec := httptest.NewRecorder()
+	handler.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, "/signup/confirm?token="+match[1], nil))
+	if getRec.Code != http.StatusOK || !strings.Contains(getRec.Body.String(), "Confirm") {
+		t.Fatalf("confirm page returned %v", getRec.Code)
+	}
+	secondDate := time.Now().AddDate(0, 0, 14).Format(time.DateOnly)
+	if !strings.Contains(getRec.Body.String(), "Race 1: "+secondDate) {
+		t.Errorf("expected the confirm page to show the race on %v", secondDate)
+	}
+	server.db.Model(&RaceSignup{}).Count(&signups)
+	if signups != 0 {
+		t.Fatalf("signup applied by opening the link")
+	}
+
+	rec = postForm(t, handler, "/signup/confirm", url.Values{"token": {match[1]}})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "signed up") {
+		t.Fatalf("confirm returned %v: %v", rec.Code, rec.Body.String())
+	}
+	server.db.Model(&RaceSignup{}).Where("race_id = ?", 2).Count(&signups)
+	if signups != 1 {
+		t.Fatalf("expected one signup for the selected race, got %v", signups)
+	}
+
+	// Links only work once
+	rec = postForm(t, handler, "/signup/confirm", url.Values{"token": {match[1]}})
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("reused link returned %v", rec.Code)
+	}
+}
+
+func TestWebSignupIgnoresNonMembers(t *testing.T) {
+	server, notifier := newTestRosterServer(t)
+
+	rec := postForm(t, server.routes(), "/signup", url.Values{
+		"email":  {"stranger@example.org"},
+		"name":   {"Stranger"},
+		"role":   {"RC"},
+		"action": {"cancel"},
+		"race":   {"1"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("submit returned %v", rec.Code)
+	}
+	if len(notifier.Sent) != 0 {
+		t.Fatalf("emailed a non-member: %v", notifier.Sent)
+	}
+}
+
+func TestWebSignupExpiredLink(t *testing.T) {
+	server, _ := newTestRosterServer(t)
+
+	request := &WebSignupRequest{Token: "abc", Email: "member@example.org", Role: "RC", Action: actionSignup, Races: "1", ExpiresAt: time.Now().Add(-time.Minute)}
+	server.db.Create(request)
+
+	rec := postForm(t, server.routes(), "/signup/confirm", url.Values{"token": {"abc"}})
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expired link returned %v", rec.Code)
+	}
+}
+
+func TestWebSignupDatabaseError(t *testing.T) {
+	server, _ := newTestRosterServer(t)
+
+	sqlDB, err := server.db.DB()
+	if err != nil {
+		t.Fatalf("getting database: %v", err)
+	}
+	sqlDB.Close()
+
+	rec := postForm(t, server.routes(), "/signup/confirm", url.Values{"token": {"abc"}})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("database error returned %v", rec.Code)
+	}
+}
+
+func TestWebSignupRejectsInvalidRace(t *testing.T) {
+	server, notifier := newTestRosterServer(t)
+
+	rec := postForm(t, server.routes(), "/signup", url.Values{
+		"email":  {"member@example.org"},
+		"name":   {"Member"},
+		"role":   {"RC"},
+		"action": {"signup"},
+		"race":   {"Race 1"},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("race name returned %v", rec.Code)
+	}
+	if len(notifier.Sent) != 0 {
+		t.Fatalf("emailed a confirmation for an invalid race: %v", notifier.Sent)
+	}
+}
+
+func TestDatabaseWaitsForLocks(t *testing.T) {
+	for _, db := range []*gorm.DB{openDatabaseFile(filepath.Join(t.TempDir(), "db.sqlite")), openMemoryDatabase()} {
+		var timeout int
+		if err := db.Raw("PRAGMA busy_timeout").Scan(&timeout).Error; err != nil {
+			t.Fatal(err)
+		}
+		if timeout != 5000 {
+			t.Errorf("expected a busy timeout of 5000, got %v", timeout)
+		}
+	}
+}